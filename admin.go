@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/charmbracelet/wish/bubbletea"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	adminUser      = "admin"       // SSH user that opens the admin console
+	maintenanceKey = "maintenance" // Message shown to users while in maintenance mode
+)
+
+// Messages shown to users affected by moderator actions.
+const (
+	kickedMessage      = "You have been disconnected by a moderator."
+	defaultMaintenance = "GoMegle is down for maintenance, please try again later."
+)
+
+// adminRefreshInterval is how often the admin dashboard reloads its statistics.
+const adminRefreshInterval = 2 * time.Second
+
+// Number of entries shown in each list of the admin dashboard.
+const (
+	adminReportRows  = 5
+	adminSessionRows = 10
+)
+
+const adminHelp = `kick <session>                       - End a session
+ban <fingerprint> [duration] [reason] - Ban a key and end its sessions, e.g. 'ban SHA256:... 24h spam'
+banip <addr|cidr> [duration] [reason] - Ban an IP address or range from connecting, e.g. 'banip 10.0.0.0/8 1h'
+announce <message>                   - Show an announcement to every session
+maintenance on [message] | off       - Toggle maintenance mode
+ctrl+c                               - Exit`
+
+// isAdmin reports whether the key with the given fingerprint may use the admin console.
+func isAdmin(fingerprint string) bool {
+	return slices.Contains(config.AdminKeys, fingerprint)
+}
+
+// kickSession ends a session with the given message. A live session is told to
+// disconnect and cleans up after itself; one nobody is listening for is ended
+// here instead.
+func kickSession(b Broker, sessionID, message string) error {
+	if ok, err := b.SIsMember(sessionsKey, sessionID); err != nil || !ok {
+		if err == nil {
+			err = fmt.Errorf("no session %q", sessionID)
+		}
+		return err
+	}
+	data, err := marshalFrame("", "", &ChatMsg{Type: ChatMsgTypeKick, Content: message})
+	if err != nil {
+		return err
+	}
+	n, err := b.Publish(userChannelPrefix+sessionID, data)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return endSession(b, sessionID, lostConnectionMessage)
+	}
+	return nil
+}
+
+// banAndKick bans the key with the given fingerprint and ends all of its sessions.
+func banAndKick(b Broker, fingerprint, reason string, duration time.Duration) error {
+	if err := banKey(b, fingerprint, reason, duration); err != nil {
+		return err
+	}
+	ban := newBan(fingerprint, reason, duration)
+	sessions, err := keySessions(b, fingerprint)
+	if err != nil {
+		return err
+	}
+	for _, sessionID := range sessions {
+		if err := kickSession(b, sessionID, ban.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// announce shows a message from the operators to every live session on every
+// instance. It returns the number of sessions that received it.
+func announce(b Broker, message string) (int64, error) {
+	data, err := marshalFrame("", "", &ChatMsg{Type: ChatMsgTypeAnnouncement, Content: message})
+	if err != nil {
+		return 0, err
+	}
+	return b.Publish(announceChannel, data)
+}
+
+// maintenanceMessage returns the message shown while in maintenance mode, or an
+// empty string if the service is open.
+func maintenanceMessage(b Broker) (string, error) {
+	msg, err := b.Get(maintenanceKey)
+	if errors.Is(err, ErrNil) {
+		return "", nil
+	}
+	return msg, err
+}
+
+// setMaintenance turns maintenance mode on with the given message, or off if
+// the message is empty. New users are turned away during maintenance.
+func setMaintenance(b Broker, message string) error {
+	if message == "" {
+		return b.Del(maintenanceKey)
+	}
+	return b.Set(maintenanceKey, message, 0)
+}
+
+// adminSession is a snapshot of a live session shown on the dashboard.
+type adminSession struct {
+	id          string // Session ID
+	fingerprint string // Key fingerprint owning the session
+	instance    string // Instance hosting the session
+	state       string // Whether the session is chatting, queued, in a room or idle
+}
+
+// adminStats is a snapshot of the whole service shown on the dashboard.
+type adminStats struct {
+	queued      int64          // Number of users waiting in the queue
+	pairs       int            // Number of ongoing chats
+	instances   map[string]int // Number of sessions per instance
+	sessions    []adminSession // Every live session
+	reports     []Report       // Reports awaiting review, oldest first
+	maintenance string         // Maintenance message, empty if open
+}
+
+// collectStats gathers a snapshot of the service from the broker.
+func collectStats(b Broker) (adminStats, error) {
+	var stats adminStats
+	var err error
+	if stats.queued, err = b.LLen(queueKey); err != nil {
+		return stats, err
+	}
+	queue, err := b.LRange(queueKey)
+	if err != nil {
+		return stats, err
+	}
+	ids, err := b.SMembers(sessionsKey)
+	if err != nil {
+		return stats, err
+	}
+	sort.Strings(ids)
+	paired := 0
+	for _, id := range ids {
+		s := adminSession{id: id, state: "idle"}
+		s.fingerprint, _ = sessionFingerprint(b, id)
+		s.instance, _ = b.Get(instancePrefix + id)
+		if ok, _ := b.Exists(pairPrefix + id); ok {
+			s.state = "chatting"
+			paired++
+		} else if slices.Contains(queue, id) {
+			s.state = "queued"
+		} else if ok, _ := b.Exists(sessionRoomPrefix + id); ok {
+			s.state = "room"
+		}
+		stats.sessions = append(stats.sessions, s)
+	}
+	stats.pairs = paired / 2
+	stats.instances = make(map[string]int)
+	instances, err := b.SMembers(instancesKey)
+	if err != nil {
+		return stats, err
+	}
+	for _, instance := range instances {
+		members, err := b.SMembers(instanceSessions + instance)
+		if err != nil {
+			return stats, err
+		}
+		if len(members) == 0 {
+			_ = b.SRem(instancesKey, instance) // The instance is gone or idle
+			continue
+		}
+		stats.instances[instance] = len(members)
+	}
+	if stats.reports, err = listReports(b); err != nil {
+		return stats, err
+	}
+	stats.maintenance, err = maintenanceMessage(b)
+	return stats, err
+}
+
+// adminStatsMsg carries freshly collected statistics to the admin console.
+// Periodic refreshes schedule the next one when they arrive.
+type adminStatsMsg struct {
+	stats    adminStats
+	err      error
+	periodic bool
+}
+
+// adminRefreshMsg asks the admin console to reload its statistics.
+type adminRefreshMsg struct{}
+
+// adminModel is the Bubble Tea model of the admin console.
+type adminModel struct {
+	width       int             // Terminal width
+	height      int             // Terminal height
+	fingerprint string          // Fingerprint of the operator's key
+	input       textinput.Model // Command input
+	stats       adminStats      // Latest statistics
+	err         error           // Error from the latest refresh
+	status      string          // Result of the latest command
+	titleStyle  lipgloss.Style  // Style for section titles
+	dimStyle    lipgloss.Style  // Style for secondary text
+	errStyle    lipgloss.Style  // Style for errors and warnings
+}
+
+// adminHandler opens the admin console for an SSH session, refusing keys that
+// are not configured as admin keys.
+func adminHandler(s ssh.Session) (tea.Model, []tea.ProgramOption) {
+	fp := gossh.FingerprintSHA256(s.PublicKey())
+	if !isAdmin(fp) {
+		wish.Fatalln(s, "Permission denied.")
+		return nil, nil
+	}
+	log.Info("Admin console opened", "fingerprint", fp)
+	r := bubbletea.MakeRenderer(s)
+	ti := textinput.New()
+	ti.Placeholder = "kick, ban, announce or maintenance"
+	ti.Prompt = "> "
+	ti.Focus()
+	return adminModel{
+		fingerprint: fp,
+		input:       ti,
+		titleStyle:  r.NewStyle().Bold(true).Foreground(lipgloss.Color("5")),
+		dimStyle:    r.NewStyle().Foreground(lipgloss.Color("8")),
+		errStyle:    r.NewStyle().Foreground(lipgloss.Color("1")),
+	}, []tea.ProgramOption{tea.WithAltScreen()}
+}
+
+// Init loads the first statistics.
+func (m adminModel) Init() tea.Cmd {
+	return tea.Batch(textinput.Blink, refreshStats(true))
+}
+
+// refreshStats collects statistics in the background.
+func refreshStats(periodic bool) tea.Cmd {
+	return func() tea.Msg {
+		stats, err := collectStats(globalBroker)
+		return adminStatsMsg{stats: stats, err: err, periodic: periodic}
+	}
+}
+
+// Update handles input and periodic refreshes of the admin console.
+func (m adminModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		m.input.Width = max(0, msg.Width-len(m.input.Prompt)-1)
+	case adminStatsMsg:
+		m.stats, m.err = msg.stats, msg.err
+		if !msg.periodic {
+			return m, nil
+		}
+		return m, tea.Tick(adminRefreshInterval, func(time.Time) tea.Msg {
+			return adminRefreshMsg{}
+		})
+	case adminRefreshMsg:
+		return m, refreshStats(true)
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "enter":
+			m.status = m.runCommand(strings.TrimSpace(m.input.Value()))
+			m.input.Reset()
+			return m, refreshStats(false)
+		}
+	}
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+// runCommand executes an admin command and returns a line describing the result.
+func (m adminModel) runCommand(line string) string {
+	name, args, _ := strings.Cut(line, " ")
+	args = strings.TrimSpace(args)
+	switch name {
+	case "":
+		return ""
+	case "kick":
+		if args == "" {
+			return "Usage: kick <session>"
+		}
+		if err := kickSession(globalBroker, args, kickedMessage); err != nil {
+			return "Error: " + err.Error()
+		}
+		log.Info("Admin kicked session", "admin", m.fingerprint, "session", args)
+		return "Kicked " + args
+	case "ban":
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			return "Usage: ban <fingerprint> [duration] [reason]"
+		}
+		fingerprint := fields[0]
+		duration, reason := parseBanArgs(fields[1:])
+		if err := banAndKick(globalBroker, fingerprint, reason, duration); err != nil {
+			return "Error: " + err.Error()
+		}
+		log.Info("Admin banned key", "admin", m.fingerprint, "fingerprint", fingerprint, "duration", duration, "reason", reason)
+		return "Banned " + fingerprint
+	case "banip":
+		fields := strings.Fields(args)
+		if len(fields) == 0 {
+			return "Usage: banip <addr|cidr> [duration] [reason]"
+		}
+		duration, reason := parseBanArgs(fields[1:])
+		if err := banAddr(globalBroker, fields[0], reason, duration); err != nil {
+			return "Error: " + err.Error()
+		}
+		log.Info("Admin banned address", "admin", m.fingerprint, "addr", fields[0], "duration", duration, "reason", reason)
+		return "Banned " + fields[0]
+	case "announce":
+		if args == "" {
+			return "Usage: announce <message>"
+		}
+		n, err := announce(globalBroker, args)
+		if err != nil {
+			return "Error: " + err.Error()
+		}
+		log.Info("Admin sent announcement", "admin", m.fingerprint, "message", args)
+		return fmt.Sprintf("Announced to %d sessions", n)
+	case "maintenance":
+		mode, message, _ := strings.Cut(args, " ")
+		switch mode {
+		case "on":
+			if message = strings.TrimSpace(message); message == "" {
+				message = defaultMaintenance
+			}
+		case "off":
+			message = ""
+		default:
+			return "Usage: maintenance on [message] | off"
+		}
+		if err := setMaintenance(globalBroker, message); err != nil {
+			return "Error: " + err.Error()
+		}
+		log.Info("Admin toggled maintenance", "admin", m.fingerprint, "mode", mode)
+		return "Maintenance mode " + mode
+	}
+	return fmt.Sprintf("Unknown command %q", name)
+}
+
+// View renders the dashboard, the command help and the command input.
+func (m adminModel) View() string {
+	var b strings.Builder
+	s := m.stats
+	b.WriteString(m.titleStyle.Render("GoMegle admin") + m.dimStyle.Render(" · "+config.Instance) + "\n\n")
+	if m.err != nil {
+		b.WriteString(m.errStyle.Render("Could not load statistics: "+m.err.Error()) + "\n\n")
+	}
+	if s.maintenance != "" {
+		b.WriteString(m.errStyle.Render("Maintenance mode: "+s.maintenance) + "\n\n")
+	}
+	fmt.Fprintf(&b, "Sessions: %d   Queued: %d   Pairs: %d   Reports: %d\n\n", len(s.sessions), s.queued, s.pairs, len(s.reports))
+
+	b.WriteString(m.titleStyle.Render("Sessions per pod") + "\n")
+	pods := make([]string, 0, len(s.instances))
+	for pod := range s.instances {
+		pods = append(pods, pod)
+	}
+	sort.Strings(pods)
+	for _, pod := range pods {
+		fmt.Fprintf(&b, "  %-30s %d\n", pod, s.instances[pod])
+	}
+
+	b.WriteString("\n" + m.titleStyle.Render("Sessions") + "\n")
+	for i, sess := range s.sessions {
+		if i == adminSessionRows {
+			b.WriteString(m.dimStyle.Render(fmt.Sprintf("  … %d more", len(s.sessions)-i)) + "\n")
+			break
+		}
+		fmt.Fprintf(&b, "  %-16s %-8s %-20s %s\n", sess.id, sess.state, sess.instance, m.dimStyle.Render(sess.fingerprint))
+	}
+
+	b.WriteString("\n" + m.titleStyle.Render("Latest reports") + "\n")
+	reports := s.reports[max(0, len(s.reports)-adminReportRows):]
+	for i := len(reports) - 1; i >= 0; i-- {
+		r := reports[i]
+		fmt.Fprintf(&b, "  %s %s %s\n", r.Time.Format(time.DateTime), r.Reported, m.dimStyle.Render(r.Reason))
+	}
+
+	b.WriteString("\n" + m.dimStyle.Render(adminHelp) + "\n\n")
+	if m.status != "" {
+		b.WriteString(m.status + "\n")
+	}
+	b.WriteString(m.input.View())
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// rejectionContextKey stores why a connection was rejected in its ssh.Context,
+// such as the ban or maintenance notice.
+type rejectionContextKey struct{}
+
+// publicKeyHandler authenticates a connecting key. The admin user only accepts
+// admin keys. Otherwise banned keys and addresses are rejected, as is everyone
+// during maintenance or while this instance drains, and keys that already have
+// a session outside of dev mode.
+func publicKeyHandler(ctx ssh.Context, key ssh.PublicKey) bool {
+	fingerprint := gossh.FingerprintSHA256(key)
+	if ctx.User() == adminUser {
+		if !isAdmin(fingerprint) {
+			log.Warn("Rejected admin login", "fingerprint", fingerprint)
+			authRejections.WithLabelValues(rejectAdmin).Inc()
+			return false
+		}
+		return true
+	}
+	ban, err := findBan(globalBroker, fingerprint, remoteIP(ctx))
+	if err != nil {
+		log.Error("Error checking bans", "error", err)
+		authRejections.WithLabelValues(rejectError).Inc()
+		return false
+	}
+	if ban != nil {
+		// Remember the ban so keyboard-interactive auth can show the reason
+		ctx.SetValue(rejectionContextKey{}, ban.String())
+		log.Info("Rejected banned user", "fingerprint", fingerprint, "target", ban.Target)
+		authRejections.WithLabelValues(rejectBanned).Inc()
+		return false
+	}
+	if localSessions.isDraining() {
+		ctx.SetValue(rejectionContextKey{}, restartingMessage)
+		authRejections.WithLabelValues(rejectDraining).Inc()
+		return false
+	}
+	maintenance, err := maintenanceMessage(globalBroker)
+	if err != nil {
+		log.Error("Error checking maintenance mode", "error", err)
+		authRejections.WithLabelValues(rejectError).Inc()
+		return false
+	}
+	if maintenance != "" {
+		ctx.SetValue(rejectionContextKey{}, maintenance)
+		authRejections.WithLabelValues(rejectMaintenance).Inc()
+		return false
+	}
+	// Check if the key already has a session
+	hasUser, err := globalMatchmaker.HasUser(fingerprint)
+	if err != nil {
+		log.Error("Error checking user in matchmaker", "error", err)
+		authRejections.WithLabelValues(rejectError).Inc()
+		return false
+	}
+	if hasUser && !config.Dev {
+		authRejections.WithLabelValues(rejectDuplicate).Inc()
+		return false
+	}
+	return true
+}
+
+// keyboardInteractiveHandler never authenticates anyone. It only exists to show
+// a rejected user why their key was refused, since SSH clients fall back to
+// keyboard-interactive auth after a public key is refused.
+func keyboardInteractiveHandler(ctx ssh.Context, challenge gossh.KeyboardInteractiveChallenge) bool {
+	reason, ok := ctx.Value(rejectionContextKey{}).(string)
+	if !ok || reason == "" {
+		return false
+	}
+	ctx.SetValue(rejectionContextKey{}, "") // Clients retry, only show the reason once
+	_, _ = challenge("", reason+"\n", nil, nil)
+	return false
+}
+
+// remoteIP returns the IP address of the connecting client, or nil if unknown.
+func remoteIP(ctx ssh.Context) net.IP {
+	host, _, err := net.SplitHostPort(ctx.RemoteAddr().String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
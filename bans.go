@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	keyBanPrefix  = "ban:key:"  // JSON encoded Ban of a key fingerprint
+	cidrBanPrefix = "ban:cidr:" // JSON encoded Ban of an IP range
+	bannedCIDRs   = "ban_cidrs" // Set of banned IP ranges, in CIDR notation
+)
+
+// Ban bars a key fingerprint or an IP range from connecting.
+type Ban struct {
+	Target  string    `json:"target"`  // Key fingerprint or CIDR
+	Reason  string    `json:"reason"`  // Reason shown to the banned user
+	Created time.Time `json:"created"` // When the ban was issued
+	Expires time.Time `json:"expires"` // When the ban ends, zero if permanent
+}
+
+// String describes the ban in a single line suitable for the banned user.
+func (b Ban) String() string {
+	msg := "You are banned from GoMegle"
+	if b.Reason != "" {
+		msg += ": " + b.Reason
+	}
+	if !b.Expires.IsZero() {
+		msg += fmt.Sprintf(" (until %s)", b.Expires.UTC().Format(time.RFC1123))
+	}
+	return msg
+}
+
+// banKey bans the key with the given fingerprint. A zero duration bans forever.
+func banKey(b Broker, fingerprint, reason string, duration time.Duration) error {
+	return storeBan(b, keyBanPrefix+fingerprint, newBan(fingerprint, reason, duration), duration)
+}
+
+// banAddr bans an IP address or CIDR range. A zero duration bans forever.
+func banAddr(b Broker, addr, reason string, duration time.Duration) error {
+	cidr, err := parseCIDR(addr)
+	if err != nil {
+		return err
+	}
+	if err := storeBan(b, cidrBanPrefix+cidr, newBan(cidr, reason, duration), duration); err != nil {
+		return err
+	}
+	return b.SAdd(bannedCIDRs, cidr)
+}
+
+// parseBanArgs splits the arguments following a ban target into an optional
+// leading duration and the reason, as in '24h spam'.
+func parseBanArgs(args []string) (time.Duration, string) {
+	var duration time.Duration
+	if len(args) > 0 {
+		if d, err := time.ParseDuration(args[0]); err == nil {
+			duration, args = d, args[1:]
+		}
+	}
+	return duration, strings.Join(args, " ")
+}
+
+// newBan creates a ban of target starting now.
+func newBan(target, reason string, duration time.Duration) Ban {
+	ban := Ban{Target: target, Reason: reason, Created: time.Now()}
+	if duration > 0 {
+		ban.Expires = ban.Created.Add(duration)
+	}
+	return ban
+}
+
+// storeBan saves a ban at key, expiring along with the ban.
+func storeBan(b Broker, key string, ban Ban, duration time.Duration) error {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+	return b.Set(key, string(data), duration)
+}
+
+// parseCIDR normalizes an IP address or CIDR range to CIDR notation.
+func parseCIDR(addr string) (string, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	_, ipNet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP address or CIDR %q", addr)
+	}
+	return ipNet.String(), nil
+}
+
+// loadBan returns the ban stored at key, or nil if there is none.
+func loadBan(b Broker, key string) (*Ban, error) {
+	data, err := b.Get(key)
+	if errors.Is(err, ErrNil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ban Ban
+	if err := json.Unmarshal([]byte(data), &ban); err != nil {
+		return nil, err
+	}
+	return &ban, nil
+}
+
+// findBan returns the ban applying to a key fingerprint or IP address, or nil if
+// neither is banned. Either argument may be empty to skip that check.
+func findBan(b Broker, fingerprint string, ip net.IP) (*Ban, error) {
+	if fingerprint != "" {
+		if ban, err := loadBan(b, keyBanPrefix+fingerprint); err != nil || ban != nil {
+			return ban, err
+		}
+	}
+	if ip == nil {
+		return nil, nil
+	}
+	cidrs, err := b.SMembers(bannedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil || !ipNet.Contains(ip) {
+			continue
+		}
+		ban, err := loadBan(b, cidrBanPrefix+cidr)
+		if err != nil {
+			return nil, err
+		}
+		if ban == nil {
+			_ = b.SRem(bannedCIDRs, cidr) // The ban has expired
+			continue
+		}
+		return ban, nil
+	}
+	return nil, nil
+}
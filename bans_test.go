@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFindBan(t *testing.T) {
+	tests := []struct {
+		name        string
+		ban         func(b Broker) error
+		fingerprint string
+		ip          string
+		banned      bool
+	}{
+		{"nothing banned", func(b Broker) error { return nil }, "SHA256:a", "192.0.2.1", false},
+		{"key", func(b Broker) error { return banKey(b, "SHA256:a", "spam", 0) }, "SHA256:a", "192.0.2.1", true},
+		{"other key", func(b Broker) error { return banKey(b, "SHA256:b", "spam", 0) }, "SHA256:a", "192.0.2.1", false},
+		{"address", func(b Broker) error { return banAddr(b, "192.0.2.1", "spam", 0) }, "SHA256:a", "192.0.2.1", true},
+		{"range", func(b Broker) error { return banAddr(b, "192.0.2.0/24", "spam", 0) }, "", "192.0.2.77", true},
+		{"outside range", func(b Broker) error { return banAddr(b, "192.0.2.0/24", "spam", 0) }, "", "198.51.100.1", false},
+		{"ipv6 range", func(b Broker) error { return banAddr(b, "2001:db8::/32", "spam", 0) }, "", "2001:db8::1", true},
+		{"expired range", func(b Broker) error {
+			err := banAddr(b, "192.0.2.0/24", "spam", 10*time.Millisecond)
+			time.Sleep(20 * time.Millisecond)
+			return err
+		}, "", "192.0.2.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			if err := tt.ban(b); err != nil {
+				t.Fatal(err)
+			}
+			ban, err := findBan(b, tt.fingerprint, net.ParseIP(tt.ip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (ban != nil) != tt.banned {
+				t.Errorf("findBan = %v, want banned %v", ban, tt.banned)
+			}
+		})
+	}
+}
+
+func TestParseBanArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		duration time.Duration
+		reason   string
+	}{
+		{nil, 0, ""},
+		{[]string{"24h"}, 24 * time.Hour, ""},
+		{[]string{"1h", "spam", "links"}, time.Hour, "spam links"},
+		{[]string{"spam"}, 0, "spam"},
+	}
+	for _, tt := range tests {
+		duration, reason := parseBanArgs(tt.args)
+		if duration != tt.duration || reason != tt.reason {
+			t.Errorf("parseBanArgs(%q) = %v, %q; want %v, %q", tt.args, duration, reason, tt.duration, tt.reason)
+		}
+	}
+}
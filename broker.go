@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Keys and channels shared by every Broker implementation.
+const (
+	queueKey          = "queue"       // List of users waiting for a match
+	usersKey          = "users"       // Set of users currently in the matchmaker
+	activeKey         = "active"      // Counter of connected users
+	userJoinedChannel = "user_joined" // Notified whenever a user is enqueued
+	userChannelPrefix = "user:"       // Prefix of each user's message channel
+	announceChannel   = "announce"    // Announcements delivered to every user
+)
+
+// ErrNil is returned by a Broker when a requested key or list element does not exist.
+var ErrNil = errors.New("broker: nil")
+
+// PubSubMsg is a single message received on a Subscription.
+type PubSubMsg struct {
+	Channel string // Channel the message was published on
+	Payload []byte // Raw message payload
+}
+
+// Subscription is a live subscription to one or more Broker channels.
+type Subscription interface {
+	// Channel returns the channel messages are delivered on. It is closed once
+	// the subscription is closed.
+	Channel() <-chan *PubSubMsg
+	// Close unsubscribes and closes the delivery channel.
+	Close() error
+}
+
+// Broker is the storage and messaging backend used by the matchmaker and users.
+// The operations mirror the small subset of Redis that GoMegle relies on so that
+// the server can run either against Redis or entirely in memory.
+type Broker interface {
+	// Get returns the value stored at key, or ErrNil if it does not exist.
+	Get(key string) (string, error)
+	// MGet returns the values stored at keys in one round trip, with an empty
+	// string for every key that does not exist.
+	MGet(keys ...string) ([]string, error)
+	// Set stores value at key. A zero ttl means the key never expires.
+	Set(key, value string, ttl time.Duration) error
+	// Del removes the given keys, ignoring keys that do not exist.
+	Del(keys ...string) error
+	// Exists reports whether key exists.
+	Exists(key string) (bool, error)
+	// Expire sets a time to live on an existing key.
+	Expire(key string, ttl time.Duration) error
+	// Incr increments the integer stored at key and returns the new value.
+	Incr(key string) (int64, error)
+	// Decr decrements the integer stored at key and returns the new value.
+	Decr(key string) (int64, error)
+
+	// RPush appends values to the tail of the list stored at key.
+	RPush(key string, values ...string) error
+	// LPush prepends values to the head of the list stored at key.
+	LPush(key string, values ...string) error
+	// LPop removes and returns the head of the list, or ErrNil if it is empty.
+	LPop(key string) (string, error)
+	// LRem removes every occurrence of value from the list and returns how many
+	// were removed.
+	LRem(key, value string) (int64, error)
+	// LLen returns the length of the list.
+	LLen(key string) (int64, error)
+	// LRange returns every element of the list, head first.
+	LRange(key string) ([]string, error)
+
+	// SAdd adds members to the set stored at key.
+	SAdd(key string, members ...string) error
+	// SRem removes members from the set stored at key.
+	SRem(key string, members ...string) error
+	// SIsMember reports whether member belongs to the set.
+	SIsMember(key, member string) (bool, error)
+	// SMembers returns every member of the set.
+	SMembers(key string) ([]string, error)
+
+	// Publish sends data on channel and returns the number of subscribers that
+	// received it.
+	Publish(channel string, data []byte) (int64, error)
+	// Subscribe listens for messages published on the given channels.
+	Subscribe(channels ...string) Subscription
+
+	// Lock acquires the lock at key for token if it is free.
+	Lock(key, token string, ttl time.Duration) (bool, error)
+	// Unlock releases the lock at key if it is held by token.
+	Unlock(key, token string) error
+	// ExtendLock refreshes the ttl of the lock at key if it is held by token.
+	ExtendLock(key, token string, ttl time.Duration) error
+
+	// TakeToken takes a token from the token bucket at key, which holds up to
+	// capacity tokens refilled at rate tokens per second, and reports whether
+	// one was available. A missing bucket is full.
+	TakeToken(key string, capacity int, rate float64) (bool, error)
+
+	// Ping checks that the backend is reachable.
+	Ping() error
+}
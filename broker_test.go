@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemoryBrokerExpiry(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		wait  time.Duration
+		found bool
+	}{
+		{"no ttl", 0, 20 * time.Millisecond, true},
+		{"before expiry", time.Hour, 0, true},
+		{"after expiry", 10 * time.Millisecond, 20 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			if err := b.Set("key", "value", tt.ttl); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(tt.wait)
+			v, err := b.Get("key")
+			if tt.found && (err != nil || v != "value") {
+				t.Errorf("Get = %q, %v; want value", v, err)
+			}
+			if !tt.found && !errors.Is(err, ErrNil) {
+				t.Errorf("Get = %q, %v; want ErrNil", v, err)
+			}
+			if ok, _ := b.Exists("key"); ok != tt.found {
+				t.Errorf("Exists = %v, want %v", ok, tt.found)
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerExpire(t *testing.T) {
+	b := NewMemoryBroker()
+	_ = b.RPush("list", "a")
+	_ = b.SAdd("set", "a")
+	for _, key := range []string{"list", "set"} {
+		if err := b.Expire(key, 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n, _ := b.LLen("list"); n != 0 {
+		t.Errorf("LLen after expiry = %d, want 0", n)
+	}
+	if ok, _ := b.SIsMember("set", "a"); ok {
+		t.Error("set member survived expiry")
+	}
+}
+
+func TestMemoryBrokerLists(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   func(b *MemoryBroker)
+		remove  string
+		removed int64
+		want    []string
+	}{
+		{"missing list", func(b *MemoryBroker) {}, "a", 0, nil},
+		{"missing value", func(b *MemoryBroker) { _ = b.RPush("list", "a", "b") }, "c", 0, []string{"a", "b"}},
+		{"every occurrence", func(b *MemoryBroker) { _ = b.RPush("list", "a", "b", "a") }, "a", 2, []string{"b"}},
+		{"pushed to head", func(b *MemoryBroker) {
+			_ = b.RPush("list", "c")
+			_ = b.LPush("list", "b", "a")
+		}, "", 0, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			tt.steps(b)
+			n, err := b.LRem("list", tt.remove)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.removed {
+				t.Errorf("LRem = %d, want %d", n, tt.removed)
+			}
+			if got, _ := b.LRange("list"); !slices.Equal(got, tt.want) {
+				t.Errorf("LRange = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerMGet(t *testing.T) {
+	b := NewMemoryBroker()
+	_ = b.Set("a", "1", 0)
+	_ = b.Set("b", "2", 10*time.Millisecond)
+	_ = b.Set("c", "3", 0)
+	time.Sleep(20 * time.Millisecond)
+	got, err := b.MGet("a", "b", "missing", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1", "", "", "3"}; !slices.Equal(got, want) {
+		t.Errorf("MGet = %q, want %q", got, want)
+	}
+}
+
+func TestMemoryBrokerLock(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  func(b *MemoryBroker)
+		token  string
+		locked bool
+	}{
+		{"free", func(b *MemoryBroker) {}, "b", true},
+		{"held", func(b *MemoryBroker) { _, _ = b.Lock("lock", "a", time.Hour) }, "b", false},
+		{"released", func(b *MemoryBroker) {
+			_, _ = b.Lock("lock", "a", time.Hour)
+			_ = b.Unlock("lock", "a")
+		}, "b", true},
+		{"released by another token", func(b *MemoryBroker) {
+			_, _ = b.Lock("lock", "a", time.Hour)
+			_ = b.Unlock("lock", "b")
+		}, "b", false},
+		{"expired", func(b *MemoryBroker) {
+			_, _ = b.Lock("lock", "a", 10*time.Millisecond)
+			time.Sleep(20 * time.Millisecond)
+		}, "b", true},
+		{"extended", func(b *MemoryBroker) {
+			_, _ = b.Lock("lock", "a", 10*time.Millisecond)
+			_ = b.ExtendLock("lock", "a", time.Hour)
+			time.Sleep(20 * time.Millisecond)
+		}, "b", false},
+		{"extended by another token", func(b *MemoryBroker) {
+			_, _ = b.Lock("lock", "a", 10*time.Millisecond)
+			_ = b.ExtendLock("lock", "b", time.Hour)
+			time.Sleep(20 * time.Millisecond)
+		}, "b", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			tt.steps(b)
+			ok, err := b.Lock("lock", tt.token, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.locked {
+				t.Errorf("Lock = %v, want %v", ok, tt.locked)
+			}
+		})
+	}
+}
+
+func TestMemoryBrokerPublish(t *testing.T) {
+	tests := []struct {
+		name      string
+		subscribe bool
+		close     bool
+		backlog   int // Messages published and left unread first
+		want      int64
+	}{
+		{"no subscriber", false, false, 0, 0},
+		{"subscriber", true, false, 0, 1},
+		{"closed subscription", true, true, 0, 0},
+		{"full subscriber drops", true, false, 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemoryBroker()
+			var sub Subscription
+			if tt.subscribe {
+				sub = b.Subscribe("channel")
+				if tt.close {
+					_ = sub.Close()
+				}
+			}
+			for range tt.backlog {
+				_, _ = b.Publish("channel", []byte("old"))
+			}
+			n, err := b.Publish("channel", []byte("new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Errorf("Publish = %d, want %d", n, tt.want)
+			}
+			if n == 1 {
+				msg := <-sub.Channel()
+				if msg.Channel != "channel" || string(msg.Payload) != "new" {
+					t.Errorf("received %q on %q", msg.Payload, msg.Channel)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// entryKind is the kind of a chat log entry, which decides how it is rendered.
+type entryKind int
+
+const (
+	entrySystem       entryKind = iota // Line from GoMegle itself
+	entryError                         // Error or warning
+	entryAnnouncement                  // Announcement from the operators
+	entrySent                          // Message sent by the user
+	entryReceived                      // Message received from a stranger
+)
+
+// deliveryState tracks whether a sent message reached the stranger.
+type deliveryState int
+
+const (
+	deliveryUntracked deliveryState = iota // Not acknowledged, such as room messages
+	deliveryPending
+	deliveryDelivered
+	deliveryFailed
+)
+
+// chatEntry is a line of the chat log. Entries are kept unstyled and rendered
+// on demand, so that theme and timestamp changes apply to the whole chat.
+type chatEntry struct {
+	kind     entryKind     // Kind of the entry
+	author   string        // Display name of the sender of a received message
+	body     string        // Text of the entry
+	at       time.Time     // When the entry was added
+	id       string        // Message ID of a sent message, for its delivery state
+	delivery deliveryState // Delivery state of a sent message
+}
+
+// add appends an entry to the chat log, stamped with the current time.
+func (m *model) add(e chatEntry) {
+	e.at = time.Now()
+	m.messages = append(m.messages, e)
+}
+
+// addSystem appends a line from GoMegle itself to the chat.
+func (m *model) addSystem(line string) {
+	m.add(chatEntry{kind: entrySystem, body: line})
+}
+
+// addError appends an error or warning to the chat.
+func (m *model) addError(line string) {
+	m.add(chatEntry{kind: entryError, body: line})
+}
+
+// setDelivery updates the delivery state of the pending sent message with the
+// given ID.
+func (m *model) setDelivery(id string, state deliveryState) {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if e := &m.messages[i]; e.kind == entrySent && e.id == id {
+			if e.delivery == deliveryPending {
+				e.delivery = state
+			}
+			return
+		}
+	}
+}
+
+// renderEntry renders a chat log entry with the styles and preferences of the
+// session.
+func (m model) renderEntry(e chatEntry) string {
+	switch e.kind {
+	case entryError:
+		return m.styles.err.Render(e.body)
+	case entryAnnouncement:
+		return m.styles.banner.Render("📢 " + e.body)
+	case entrySent:
+		var marker string
+		switch e.delivery {
+		case deliveryPending:
+			marker = " …"
+		case deliveryDelivered:
+			marker = " ✓"
+		case deliveryFailed:
+			marker = " ✗ not delivered"
+		}
+		return m.stamp(e.at) + m.styles.sender.Render("You: ") + m.styles.text.Render(e.body) + m.styles.dim.Render(marker)
+	case entryReceived:
+		return m.stamp(e.at) + m.styles.receiver.Render(e.author+": ") + m.styles.text.Render(e.body)
+	}
+	return m.styles.system.Render(e.body)
+}
+
+// refreshViewport renders the chat log into the viewport, wrapped to its
+// width, and scrolls to the bottom.
+func (m *model) refreshViewport() {
+	lines := make([]string, len(m.messages))
+	for i, e := range m.messages {
+		lines[i] = m.renderEntry(e)
+	}
+	m.viewport.SetContent(m.renderer.NewStyle().Width(m.viewport.Width).Render(strings.Join(lines, "\n")))
+	m.viewport.GotoBottom()
+}
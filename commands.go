@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// argMode is whether a command takes an argument.
+type argMode int
+
+const (
+	argNone     argMode = iota // No argument
+	argOptional                // Argument may be left out
+	argRequired                // Argument must be given
+)
+
+// command is a backslash command typed in the chat.
+type command struct {
+	name        string                                     // Name typed after the backslash
+	aliases     []string                                   // Other names of the command
+	arg         argMode                                    // Whether the command takes an argument
+	argName     string                                     // Name of the argument shown in usage
+	states      []ChatState                                // Chat states the command works in, all if empty
+	description string                                     // Line shown in help
+	example     string                                     // Example shown in help, if any
+	run         func(m model, arg string) (model, tea.Cmd) // Runs the command with its trimmed argument
+}
+
+// commands is the registry of backslash commands, in the order shown in help.
+var commands = []command{
+	{name: "h", aliases: []string{"help"}, description: "Show this help menu", run: model.cmdHelp},
+	{name: "q", aliases: []string{"quit", "leave"}, states: []ChatState{StateChatMatched, StateChatQueued, StateChatRoom}, description: "Disconnect from current chat, room or queue", run: model.cmdQuit},
+	{name: "r", aliases: []string{"requeue"}, states: []ChatState{StateChatDisconnected}, description: "Requeue for a new chat, or rejoin the room", run: model.cmdRequeue},
+	{name: "l", aliases: []string{"lobby"}, description: "Return to the lobby to change mode, interests or nickname", run: model.cmdLobby},
+	{name: "a", aliases: []string{"auto"}, description: "Toggle auto-requeue", run: model.cmdAutoRequeue},
+	{name: "s", aliases: []string{"settings"}, description: "Open settings", run: model.cmdSettings},
+	{name: "theme", arg: argOptional, argName: "name", description: "Show or set the color theme", example: `\theme high-contrast`, run: model.cmdTheme},
+	{name: "c", aliases: []string{"clear"}, description: "Clear chat window", run: model.cmdClear},
+	{name: "t", aliases: []string{"tags"}, arg: argOptional, argName: "tags", description: "Show or set interest tags", example: `\t go,music`, run: model.cmdTags},
+	{name: "block", states: []ChatState{StateChatMatched}, description: "Block the stranger from matching you again and leave", run: model.cmdBlock},
+	{name: "report", arg: argRequired, argName: "reason", states: []ChatState{StateChatMatched}, description: "Report the stranger and leave", example: `\report spam`, run: model.cmdReport},
+}
+
+// lookupCommand returns the command with the given name or alias.
+func lookupCommand(name string) (*command, bool) {
+	for i := range commands {
+		if commands[i].name == name || slices.Contains(commands[i].aliases, name) {
+			return &commands[i], true
+		}
+	}
+	return nil, false
+}
+
+// parseCommand splits a line of input into a command name and its trimmed
+// argument. It reports false if the line is not a command.
+func parseCommand(line string) (name, arg string, ok bool) {
+	line, ok = strings.CutPrefix(strings.TrimSpace(line), "\\")
+	if !ok {
+		return "", "", false
+	}
+	name, arg, _ = strings.Cut(line, " ")
+	return strings.ToLower(name), strings.TrimSpace(arg), true
+}
+
+// usage returns how the command is typed, e.g. `\report <reason>`.
+func (c command) usage() string {
+	switch c.arg {
+	case argOptional:
+		return `\` + c.name + " [" + c.argName + "]"
+	case argRequired:
+		return `\` + c.name + " <" + c.argName + ">"
+	}
+	return `\` + c.name
+}
+
+// validIn reports whether the command works in chat state s.
+func (c command) validIn(s ChatState) bool {
+	return len(c.states) == 0 || slices.Contains(c.states, s)
+}
+
+// runCommand parses and runs a command line, checking its argument and the
+// chat state first.
+func (m model) runCommand(line string) (model, tea.Cmd) {
+	name, arg, _ := parseCommand(line)
+	c, ok := lookupCommand(name)
+	if !ok {
+		hint := ""
+		if suggestion := suggestCommand(name); suggestion != "" {
+			hint = fmt.Sprintf(" Did you mean '\\%s'?", suggestion)
+		}
+		m.addError(fmt.Sprintf("Unknown command '\\%s'.%s Send '\\h' for help.", name, hint))
+		return m, nil
+	}
+	switch {
+	case c.arg == argNone && arg != "", c.arg == argRequired && arg == "":
+		m.addSystem("Usage: " + c.usage())
+		return m, nil
+	case !c.validIn(m.chatState):
+		m.addSystem(fmt.Sprintf("You can only use '\\%s' while %s.", c.name, joinStates(c.states)))
+		return m, nil
+	}
+	return c.run(m, arg)
+}
+
+// joinStates lists chat states in a sentence, e.g. "in a chat or queued".
+func joinStates(states []ChatState) string {
+	names := make([]string, len(states))
+	for i, s := range states {
+		names[i] = s.String()
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// suggestCommand returns the command name or alias closest to an unknown name,
+// or an empty string if none is close enough to be a typo.
+func suggestCommand(name string) string {
+	best, bestDistance := "", 3 // At most two edits away
+	for _, c := range commands {
+		for _, candidate := range append([]string{c.name}, c.aliases...) {
+			// Single letter names are a typo of anything, so only suggest them exactly
+			if len(candidate) < 2 && !strings.HasPrefix(name, candidate) {
+				continue
+			}
+			if d := editDistance(name, candidate); d < bestDistance {
+				best, bestDistance = candidate, d
+			}
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// completeCommand completes the command name being typed in the input. A
+// unique match is completed in full; otherwise the input is extended to the
+// longest common prefix and the candidates are listed.
+func (m model) completeCommand() model {
+	value := m.textarea.Value()
+	prefix, ok := strings.CutPrefix(value, "\\")
+	if !ok || strings.ContainsAny(prefix, " \n") {
+		return m
+	}
+	prefix = strings.ToLower(prefix)
+	var matches []*command
+	var names []string
+	for i := range commands {
+		for _, name := range append([]string{commands[i].name}, commands[i].aliases...) {
+			if strings.HasPrefix(name, prefix) {
+				matches = append(matches, &commands[i])
+				names = append(names, name)
+			}
+		}
+	}
+	switch len(names) {
+	case 0:
+		return m
+	case 1:
+		completed := `\` + names[0]
+		if matches[0].arg != argNone {
+			completed += " "
+		}
+		m.textarea.SetValue(completed)
+		return m
+	}
+	common := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if common != prefix {
+		m.textarea.SetValue(`\` + common)
+		return m
+	}
+	for i := range names {
+		names[i] = `\` + names[i]
+	}
+	m.addSystem("Commands: " + strings.Join(names, ", "))
+	m.refreshViewport()
+	return m
+}
+
+// helpText generates the help menu from the command registry.
+func helpText() string {
+	width := len("ctrl+c")
+	for _, c := range commands {
+		width = max(width, len(c.usage()))
+	}
+	var b strings.Builder
+	line := func(keys, description string) {
+		fmt.Fprintf(&b, "%-*s - %s\n", width, keys, description)
+	}
+	b.WriteString("\n")
+	for _, c := range commands {
+		description := c.description
+		if c.example != "" {
+			description += ", e.g. '" + c.example + "'"
+		}
+		if len(c.aliases) > 0 {
+			description += " (also \\" + strings.Join(c.aliases, ", \\") + ")"
+		}
+		line(c.usage(), description)
+	}
+	b.WriteString("\n")
+	line("tab", "Complete a command name")
+	line("q", "Exit this help menu")
+	line("ctrl+c", "Exit the app at any time")
+	return b.String()
+}
+
+// cmdHelp opens the help menu.
+func (m model) cmdHelp(string) (model, tea.Cmd) {
+	m.uiState = StateUIHelp
+	return m, nil
+}
+
+// cmdQuit leaves the current chat, room or queue.
+func (m model) cmdQuit(string) (model, tea.Cmd) {
+	switch m.chatState {
+	case StateChatMatched:
+		if err := m.user.LeaveChat(); err == nil {
+			m.chatState = StateChatDisconnected
+			m.addSystem("You have left the chat. Send '\\r' to requeue or press 'ctrl+c' to exit.")
+		} else {
+			m.addError("Error: Could not leave chat. Try again later.")
+		}
+	case StateChatQueued:
+		if err := globalMatchmaker.Dequeue(m.user); err == nil {
+			m.chatState = StateChatDisconnected
+			m.addSystem("You have left the queue. Send '\\r' to requeue or press 'ctrl+c' to exit.")
+		} else {
+			m.addError("Error: Could not leave queue. Try again later.")
+		}
+	case StateChatRoom:
+		if err := m.user.LeaveRoom(); err == nil {
+			m.chatState = StateChatDisconnected
+			m.addSystem("You have left the room. Send '\\r' to rejoin, '\\l' for the lobby or press 'ctrl+c' to exit.")
+		} else {
+			m.addError("Error: Could not leave the room. Try again later.")
+		}
+	}
+	return m, nil
+}
+
+// cmdRequeue starts over in the mode chosen in the lobby.
+func (m model) cmdRequeue(string) (model, tea.Cmd) {
+	if problem := m.join(); problem != "" {
+		m.addError("Error: " + problem)
+	}
+	return m, nil
+}
+
+// cmdLobby returns to the lobby, leaving the queue or room first.
+func (m model) cmdLobby(string) (model, tea.Cmd) {
+	switch m.chatState {
+	case StateChatMatched:
+		m.addSystem("Send '\\q' to leave the chat before returning to the lobby.")
+		return m, nil
+	case StateChatQueued:
+		if err := globalMatchmaker.Dequeue(m.user); err != nil {
+			m.addError("Error: Could not leave queue. Try again later.")
+			return m, nil
+		}
+	case StateChatRoom:
+		if err := m.user.LeaveRoom(); err != nil {
+			m.addError("Error: Could not leave the room. Try again later.")
+			return m, nil
+		}
+	}
+	m.chatState = StateChatDisconnected
+	return m.openLobby()
+}
+
+// cmdAutoRequeue toggles auto-requeue and saves it.
+func (m model) cmdAutoRequeue(string) (model, tea.Cmd) {
+	m.prefs.AutoRequeue = !m.prefs.AutoRequeue
+	var status string
+	if m.prefs.AutoRequeue {
+		status = "enabled"
+	} else {
+		status = "disabled"
+	}
+	m.addSystem(fmt.Sprintf("Auto-requeue %s. Send '\\h' for help.", status))
+	if err := savePreferences(globalBroker, m.user.fingerprint, m.prefs); err != nil {
+		m.addError("Error: Could not save settings. They apply to this session only.")
+	}
+	return m, nil
+}
+
+// cmdSettings opens the settings screen.
+func (m model) cmdSettings(string) (model, tea.Cmd) {
+	return m.openSettings(), nil
+}
+
+// cmdTheme shows the current theme, or switches to the named theme and saves it.
+func (m model) cmdTheme(name string) (model, tea.Cmd) {
+	if name == "" {
+		m.addSystem("Current theme: " + m.prefs.Theme + ". Available themes: " + strings.Join(themeNames(), ", ") + ".")
+		return m, nil
+	}
+	name = strings.ToLower(name)
+	if _, ok := themeByName(name); !ok {
+		m.addError("Unknown theme " + name + ". Available themes: " + strings.Join(themeNames(), ", ") + ".")
+		return m, nil
+	}
+	m.prefs.Theme = name
+	m = m.applyTheme(name)
+	m.addSystem("Theme set to " + name + ".")
+	if err := savePreferences(globalBroker, m.user.fingerprint, m.prefs); err != nil {
+		m.addError("Error: Could not save settings. They apply to this session only.")
+	}
+	return m, nil
+}
+
+// cmdClear clears the chat window.
+func (m model) cmdClear(string) (model, tea.Cmd) {
+	m.messages = nil
+	m.addSystem("Chat Cleared. Currently " + m.chatState.String() + "!")
+	return m, nil
+}
+
+// cmdTags shows the interest tags chosen in the lobby, or replaces them and
+// switches between random and interest chat to match.
+func (m model) cmdTags(arg string) (model, tea.Cmd) {
+	tags := parseTags(m.lobby.tags.Value())
+	if arg == "" {
+		if len(tags) == 0 {
+			m.addSystem("No interest tags set. Send '\\t go,music' to set some.")
+		} else {
+			m.addSystem("Your interest tags: " + strings.Join(tags, ", "))
+		}
+		return m, nil
+	}
+	tags = parseTags(arg)
+	m.lobby.tags.SetValue(strings.Join(tags, ", "))
+	switch {
+	case len(tags) > 0 && m.lobby.mode == modeRandom:
+		m.lobby.mode = modeInterest
+	case len(tags) == 0 && m.lobby.mode == modeInterest:
+		m.lobby.mode = modeRandom
+	}
+	if len(tags) == 0 {
+		m.addSystem("Interest tags cleared.")
+	} else {
+		m.addSystem("Interest tags set to: " + strings.Join(tags, ", ") + ". They apply the next time you queue.")
+	}
+	return m, nil
+}
+
+// cmdBlock blocks the stranger from matching the user again and leaves.
+func (m model) cmdBlock(string) (model, tea.Cmd) {
+	if err := m.user.BlockPartner(); err == nil {
+		m.chatState = StateChatDisconnected
+		m.addSystem("Stranger blocked, you will not be matched again. Send '\\r' to requeue or press 'ctrl+c' to exit.")
+	} else {
+		m.addError("Error: Could not block stranger. Try again later.")
+	}
+	return m, nil
+}
+
+// cmdReport reports the stranger for the given reason and leaves.
+func (m model) cmdReport(reason string) (model, tea.Cmd) {
+	if err := m.user.ReportPartner(reason); err == nil {
+		m.chatState = StateChatDisconnected
+		m.addSystem("Thanks, the stranger has been reported and you have left the chat. Send '\\r' to requeue or press 'ctrl+c' to exit.")
+	} else {
+		m.addError("Error: Could not file report. Try again later.")
+	}
+	return m, nil
+}
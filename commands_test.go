@@ -0,0 +1,107 @@
+package main
+
+import "testing"
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		line      string
+		name      string
+		arg       string
+		isCommand bool
+	}{
+		{"hello", "", "", false},
+		{"", "", "", false},
+		{" \\q ", "q", "", true},
+		{"\\Theme  High-Contrast ", "theme", "High-Contrast", true},
+		{"\\report spam and  links", "report", "spam and  links", true},
+		{"\\", "", "", true},
+	}
+	for _, tt := range tests {
+		name, arg, ok := parseCommand(tt.line)
+		if name != tt.name || arg != tt.arg || ok != tt.isCommand {
+			t.Errorf("parseCommand(%q) = %q, %q, %v; want %q, %q, %v", tt.line, name, arg, ok, tt.name, tt.arg, tt.isCommand)
+		}
+	}
+}
+
+func TestLookupCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"q", "q"},
+		{"quit", "q"},
+		{"tags", "t"},
+		{"theme", "theme"},
+		{"nope", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if c, ok := lookupCommand(tt.name); ok {
+			got = c.name
+		}
+		if got != tt.want {
+			t.Errorf("lookupCommand(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSuggestCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"thme", "theme"},
+		{"blok", "block"},
+		{"reprot", "report"},
+		{"setings", "settings"},
+		{"qq", "q"},
+		{"x", ""},
+		{"zzzzzz", ""},
+	}
+	for _, tt := range tests {
+		if got := suggestCommand(tt.name); got != tt.want {
+			t.Errorf("suggestCommand(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"theme", "theme", 0},
+		{"thme", "theme", 1},
+		{"reprot", "report", 2},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"h", `\h`},
+		{"theme", `\theme [name]`},
+		{"report", `\report <reason>`},
+	}
+	for _, tt := range tests {
+		c, ok := lookupCommand(tt.name)
+		if !ok {
+			t.Fatalf("no command %q", tt.name)
+		}
+		if got := c.usage(); got != tt.want {
+			t.Errorf("usage of %q = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the server. It is loaded by loadConfig from,
+// in increasing order of precedence, the defaults, an optional YAML file, the
+// environment and the command line flags.
+type Config struct {
+	Host            string           `yaml:"host"`              // Address the SSH server listens on
+	Port            int              `yaml:"port"`              // Port the SSH server listens on
+	HostKeyPath     string           `yaml:"host_key_path"`     // Path of the SSH host key, generated if missing
+	Broker          string           `yaml:"broker"`            // Backend to use, redis or memory
+	RedisURL        string           `yaml:"redis_url"`         // Address of the Redis server
+	Dev             bool             `yaml:"dev"`               // Development mode, allowing duplicate keys
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout"`  // How long to wait for connections to close on shutdown
+	DrainNotice     time.Duration    `yaml:"drain_notice"`      // Countdown shown to sessions before shutdown
+	HTTPAddr        string           `yaml:"http_addr"`         // Address of the metrics, health and status server, off if empty
+	Instance        string           `yaml:"instance"`          // Name of this instance, such as the pod name
+	AdminKeys       []string         `yaml:"admin_keys"`        // Fingerprints of the keys allowed to use the admin console
+	SharedRateLimit bool             `yaml:"shared_rate_limit"` // Whether message rate limits are shared across instances
+	Matchmaker      MatchmakerConfig `yaml:"matchmaker"`
+	Filters         FilterConfig     `yaml:"filters"`
+	UI              UIConfig         `yaml:"ui"`
+}
+
+// FilterConfig configures the filters applied to outgoing chat messages.
+type FilterConfig struct {
+	AllowLinks      bool     `yaml:"allow_links"`      // Whether links are kept instead of stripped
+	ProfanityWords  []string `yaml:"profanity_words"`  // Words to redact or reject
+	ProfanityRegex  string   `yaml:"profanity_regex"`  // Regular expression of further words to redact or reject
+	ProfanityReject bool     `yaml:"profanity_reject"` // Whether profanity rejects the message instead of being redacted
+}
+
+// UIConfig configures the chat interface.
+type UIConfig struct {
+	CharLimit      int           `yaml:"char_limit"`      // Maximum length of a chat message
+	SplashDuration time.Duration `yaml:"splash_duration"` // How long the splash screen is shown
+}
+
+// config is the configuration of the running server.
+var config = defaultConfig()
+
+// defaultConfig returns the configuration used when nothing is overridden.
+func defaultConfig() Config {
+	instance, _ := os.Hostname()
+	return Config{
+		Host:            "localhost",
+		Port:            23234,
+		HostKeyPath:     ".ssh/id_ed25519",
+		Broker:          "redis",
+		RedisURL:        "localhost:6379",
+		ShutdownTimeout: 30 * time.Second,
+		DrainNotice:     10 * time.Second,
+		Instance:        instance,
+		Matchmaker: MatchmakerConfig{
+			TagWait:     10 * time.Second,
+			RecentTTL:   10 * time.Minute,
+			RematchWait: 15 * time.Second,
+		},
+		UI: UIConfig{
+			CharLimit:      280,
+			SplashDuration: 2 * time.Second,
+		},
+	}
+}
+
+// stringList is a flag.Value holding a comma separated list.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = splitList(v)
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty elements.
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// bindFlags defines a flag for every setting of cfg.
+func bindFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Host, "host", cfg.Host, "address the SSH server listens on (HOST)")
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "port the SSH server listens on (PORT)")
+	fs.StringVar(&cfg.HostKeyPath, "host-key", cfg.HostKeyPath, "path of the SSH host key (HOST_KEY_PATH)")
+	fs.StringVar(&cfg.Broker, "broker", cfg.Broker, "backend to use, redis or memory (BROKER)")
+	fs.StringVar(&cfg.RedisURL, "redis-url", cfg.RedisURL, "address of the Redis server (REDIS_URL)")
+	fs.BoolVar(&cfg.Dev, "dev", cfg.Dev, "development mode, allowing duplicate keys (ENVIRONMENT=development)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long to wait for connections to close on shutdown (SHUTDOWN_TIMEOUT)")
+	fs.DurationVar(&cfg.DrainNotice, "drain-notice", cfg.DrainNotice, "countdown shown to sessions before shutdown (DRAIN_NOTICE)")
+	fs.StringVar(&cfg.HTTPAddr, "http-addr", cfg.HTTPAddr, "address of the metrics, health and status server (HTTP_ADDR)")
+	fs.StringVar(&cfg.Instance, "instance", cfg.Instance, "name of this instance (POD_NAME)")
+	fs.Var((*stringList)(&cfg.AdminKeys), "admin-keys", "comma separated fingerprints of the admin keys (ADMIN_KEYS)")
+	fs.BoolVar(&cfg.SharedRateLimit, "shared-rate-limit", cfg.SharedRateLimit, "share message rate limits across instances (RATE_LIMIT_SHARED)")
+	fs.DurationVar(&cfg.Matchmaker.TagWait, "tag-wait", cfg.Matchmaker.TagWait, "how long to hold out for a shared tag (TAG_WAIT)")
+	fs.DurationVar(&cfg.Matchmaker.RecentTTL, "recent-partner-ttl", cfg.Matchmaker.RecentTTL, "how long partners are remembered (RECENT_PARTNER_TTL)")
+	fs.DurationVar(&cfg.Matchmaker.RematchWait, "rematch-wait", cfg.Matchmaker.RematchWait, "how long before recent partners are rematched (REMATCH_WAIT)")
+	fs.BoolVar(&cfg.Filters.AllowLinks, "allow-links", cfg.Filters.AllowLinks, "keep links in messages (ALLOW_LINKS)")
+	fs.Var((*stringList)(&cfg.Filters.ProfanityWords), "profanity-words", "comma separated words to filter (PROFANITY_WORDS)")
+	fs.StringVar(&cfg.Filters.ProfanityRegex, "profanity-regex", cfg.Filters.ProfanityRegex, "regular expression of words to filter (PROFANITY_REGEX)")
+	fs.BoolVar(&cfg.Filters.ProfanityReject, "profanity-reject", cfg.Filters.ProfanityReject, "reject profanity instead of redacting it (PROFANITY_REJECT)")
+	fs.IntVar(&cfg.UI.CharLimit, "char-limit", cfg.UI.CharLimit, "maximum length of a chat message (CHAR_LIMIT)")
+	fs.DurationVar(&cfg.UI.SplashDuration, "splash-duration", cfg.UI.SplashDuration, "how long the splash screen is shown (SPLASH_DURATION)")
+}
+
+// loadConfig builds the configuration from the defaults, the YAML file named by
+// -config or CONFIG_FILE, the environment and the flags in args, each
+// overriding the previous. It returns the arguments left after the flags and
+// whether -print-config was given.
+func loadConfig(args []string) (cfg Config, rest []string, printConfig bool, err error) {
+	cfg = defaultConfig()
+	fs := flag.NewFlagSet("gomegle", flag.ExitOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path of a YAML configuration file (CONFIG_FILE)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the resolved configuration and exit")
+	bindFlags(fs, &cfg)
+	_ = fs.Parse(args) // Exits on error
+
+	// Flags take precedence, so remember them before loading everything else
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	cfg = defaultConfig()
+	if *path != "" {
+		if err := loadConfigFile(*path, &cfg); err != nil {
+			return cfg, nil, false, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, nil, false, err
+	}
+	for name, value := range set {
+		_ = fs.Set(name, value) // Already parsed once
+	}
+	return cfg, fs.Args(), printConfig, cfg.validate()
+}
+
+// loadConfigFile overrides cfg with the settings of the YAML file at path.
+// Unknown settings are an error.
+func loadConfigFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:all
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with the settings present in the environment.
+func (cfg *Config) applyEnv() error {
+	var errs []error
+	envString := func(name string, s *string) {
+		if v := os.Getenv(name); v != "" {
+			*s = v
+		}
+	}
+	envBool := func(name string, b *bool) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			*b = parsed
+		}
+	}
+	envInt := func(name string, n *int) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			*n = parsed
+		}
+	}
+	envDuration := func(name string, d *time.Duration) {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+			*d = parsed
+		}
+	}
+	envList := func(name string, l *[]string) {
+		if v := os.Getenv(name); v != "" {
+			*l = splitList(v)
+		}
+	}
+
+	envString("HOST", &cfg.Host)
+	envInt("PORT", &cfg.Port)
+	envString("HOST_KEY_PATH", &cfg.HostKeyPath)
+	envString("BROKER", &cfg.Broker)
+	envString("REDIS_URL", &cfg.RedisURL)
+	if v := os.Getenv("ENVIRONMENT"); v != "" {
+		cfg.Dev = v == "development"
+	}
+	envDuration("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	envDuration("DRAIN_NOTICE", &cfg.DrainNotice)
+	envString("HTTP_ADDR", &cfg.HTTPAddr)
+	envString("POD_NAME", &cfg.Instance)
+	envList("ADMIN_KEYS", &cfg.AdminKeys)
+	envBool("RATE_LIMIT_SHARED", &cfg.SharedRateLimit)
+	envDuration("TAG_WAIT", &cfg.Matchmaker.TagWait)
+	envDuration("RECENT_PARTNER_TTL", &cfg.Matchmaker.RecentTTL)
+	envDuration("REMATCH_WAIT", &cfg.Matchmaker.RematchWait)
+	envBool("ALLOW_LINKS", &cfg.Filters.AllowLinks)
+	envList("PROFANITY_WORDS", &cfg.Filters.ProfanityWords)
+	envString("PROFANITY_REGEX", &cfg.Filters.ProfanityRegex)
+	envBool("PROFANITY_REJECT", &cfg.Filters.ProfanityReject)
+	envInt("CHAR_LIMIT", &cfg.UI.CharLimit)
+	envDuration("SPLASH_DURATION", &cfg.UI.SplashDuration)
+	return errors.Join(errs...)
+}
+
+// validate reports every invalid setting of cfg.
+func (cfg Config) validate() error {
+	var errs []error
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range", cfg.Port))
+	}
+	if cfg.HostKeyPath == "" {
+		errs = append(errs, errors.New("host key path is empty"))
+	}
+	switch cfg.Broker {
+	case "redis":
+		if cfg.RedisURL == "" {
+			errs = append(errs, errors.New("redis url is empty"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("broker %q is not redis or memory", cfg.Broker))
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"shutdown timeout", cfg.ShutdownTimeout},
+		{"drain notice", cfg.DrainNotice},
+		{"tag wait", cfg.Matchmaker.TagWait},
+		{"recent partner ttl", cfg.Matchmaker.RecentTTL},
+		{"rematch wait", cfg.Matchmaker.RematchWait},
+		{"splash duration", cfg.UI.SplashDuration},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s %s is negative", d.name, d.value))
+		}
+	}
+	if cfg.UI.CharLimit < 1 {
+		errs = append(errs, fmt.Errorf("char limit %d is not positive", cfg.UI.CharLimit))
+	}
+	if cfg.Filters.ProfanityRegex != "" {
+		if _, err := regexp.Compile(cfg.Filters.ProfanityRegex); err != nil {
+			errs = append(errs, fmt.Errorf("profanity regex: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("port: 2000\nbroker: memory\nmatchmaker:\n  tag_wait: 3s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unknown := filepath.Join(t.TempDir(), "unknown.yaml")
+	if err := os.WriteFile(unknown, []byte("prot: 2000\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		port    int
+		broker  string
+		tagWait time.Duration
+		rest    []string
+		wantErr bool
+	}{
+		{name: "defaults", port: defaultConfig().Port, broker: defaultConfig().Broker, tagWait: defaultConfig().Matchmaker.TagWait},
+		{name: "file", args: []string{"-config", file}, port: 2000, broker: "memory", tagWait: 3 * time.Second},
+		{name: "file from env", env: map[string]string{"CONFIG_FILE": file}, port: 2000, broker: "memory", tagWait: 3 * time.Second},
+		{name: "env over file", env: map[string]string{"PORT": "3000", "TAG_WAIT": "4s"}, args: []string{"-config", file}, port: 3000, broker: "memory", tagWait: 4 * time.Second},
+		{name: "flag over env", env: map[string]string{"PORT": "3000"}, args: []string{"-config", file, "-port", "4000"}, port: 4000, broker: "memory", tagWait: 3 * time.Second},
+		{name: "flag over file", args: []string{"-port", "4000", "-tag-wait", "5s", "-config", file}, port: 4000, broker: "memory", tagWait: 5 * time.Second},
+		{name: "arguments after flags", args: []string{"-broker", "memory", "announce", "hi"}, port: defaultConfig().Port, broker: "memory", tagWait: defaultConfig().Matchmaker.TagWait, rest: []string{"announce", "hi"}},
+		{name: "unknown file setting", args: []string{"-config", unknown}, wantErr: true},
+		{name: "invalid env", env: map[string]string{"PORT": "many"}, wantErr: true},
+		{name: "invalid setting", args: []string{"-broker", "etcd"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CONFIG_FILE", "PORT", "BROKER", "TAG_WAIT", "REDIS_URL"} {
+				t.Setenv(name, tt.env[name])
+			}
+			cfg, rest, _, err := loadConfig(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cfg.Port != tt.port || cfg.Broker != tt.broker || cfg.Matchmaker.TagWait != tt.tagWait {
+				t.Errorf("port, broker, tag wait = %d, %q, %v; want %d, %q, %v", cfg.Port, cfg.Broker, cfg.Matchmaker.TagWait, tt.port, tt.broker, tt.tagWait)
+			}
+			if !slices.Equal(rest, tt.rest) && len(rest)+len(tt.rest) > 0 {
+				t.Errorf("rest = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// MessageFilter inspects an outgoing chat message before it is published. It
+// returns the content to send, which may be rewritten or redacted, or a
+// *RejectedError if the message must not be sent at all.
+type MessageFilter interface {
+	Filter(content string) (string, error)
+}
+
+// RejectedError is returned by a MessageFilter that refuses a message.
+type RejectedError struct {
+	Reason string // Explanation shown to the sender
+}
+
+func (e *RejectedError) Error() string {
+	return "message rejected: " + e.Reason
+}
+
+// FilterChain runs messages through an ordered list of filters.
+type FilterChain []MessageFilter
+
+// Apply passes content through every filter in order, stopping at the first
+// rejection.
+func (fc FilterChain) Apply(content string) (string, error) {
+	for _, f := range fc {
+		var err error
+		if content, err = f.Filter(content); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+// WordFilter catches profanity from a wordlist and extra regular expressions.
+// Matches are redacted, or the whole message is rejected if Reject is set.
+type WordFilter struct {
+	patterns []*regexp.Regexp
+	Reject   bool
+}
+
+// NewWordFilter builds a filter matching each word as a whole word, case
+// insensitively, plus each of the given regular expressions.
+func NewWordFilter(words, expressions []string, reject bool) (*WordFilter, error) {
+	wf := &WordFilter{Reject: reject}
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			wf.patterns = append(wf.patterns, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(w)+`\b`))
+		}
+	}
+	for _, expr := range expressions {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter expression %q: %w", expr, err)
+		}
+		wf.patterns = append(wf.patterns, re)
+	}
+	return wf, nil
+}
+
+func (wf *WordFilter) Filter(content string) (string, error) {
+	for _, re := range wf.patterns {
+		if !re.MatchString(content) {
+			continue
+		}
+		if wf.Reject {
+			return "", &RejectedError{Reason: "Your message contains language that is not allowed."}
+		}
+		content = re.ReplaceAllStringFunc(content, func(match string) string {
+			return strings.Repeat("*", len([]rune(match)))
+		})
+	}
+	return content, nil
+}
+
+// linkPattern matches URLs and bare www. links.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)\S+`)
+
+// LinkFilter strips links from messages.
+type LinkFilter struct{}
+
+func (LinkFilter) Filter(content string) (string, error) {
+	return linkPattern.ReplaceAllString(content, "[link removed]"), nil
+}
+
+// NormalizeFilter cleans up abusive text: it drops control and bidi override
+// characters, caps stacked combining marks, limits the number of lines and
+// rejects messages left empty.
+type NormalizeFilter struct {
+	MaxLines int // Maximum number of lines kept, zero for no limit
+	MaxMarks int // Maximum combining marks kept per character
+}
+
+func (nf NormalizeFilter) Filter(content string) (string, error) {
+	var b strings.Builder
+	marks := 0
+	for _, r := range content {
+		switch {
+		case r == '\n':
+			marks = 0
+		case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r):
+			if marks++; marks > nf.MaxMarks {
+				continue
+			}
+		case unicode.IsControl(r) || unicode.Is(unicode.Bidi_Control, r):
+			continue
+		default:
+			marks = 0
+		}
+		b.WriteRune(r)
+	}
+	lines := strings.Split(b.String(), "\n")
+	if nf.MaxLines > 0 && len(lines) > nf.MaxLines {
+		lines = lines[:nf.MaxLines]
+	}
+	content = strings.TrimSpace(strings.Join(lines, "\n"))
+	if content == "" {
+		return "", &RejectedError{Reason: "Your message is empty after removing disallowed characters."}
+	}
+	return content, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFilterChain(t *testing.T) {
+	redact, err := NewWordFilter([]string{"darn"}, []string{`(?i)h[e3]ck`}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reject, err := NewWordFilter([]string{"darn"}, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalize := NormalizeFilter{MaxLines: 2, MaxMarks: 1}
+	tests := []struct {
+		name     string
+		chain    FilterChain
+		in       string
+		want     string
+		rejected bool
+	}{
+		{"no filters", nil, "hello", "hello", false},
+		{"clean message", FilterChain{normalize, LinkFilter{}, redact}, "hello there", "hello there", false},
+		{"link removed", FilterChain{LinkFilter{}}, "see https://example.com now", "see [link removed] now", false},
+		{"www link removed", FilterChain{LinkFilter{}}, "www.example.com", "[link removed]", false},
+		{"word redacted", FilterChain{redact}, "Darn it", "**** it", false},
+		{"word inside another kept", FilterChain{redact}, "darned", "darned", false},
+		{"expression redacted", FilterChain{redact}, "what the H3CK", "what the ****", false},
+		{"word rejected", FilterChain{reject}, "darn", "", true},
+		{"control characters dropped", FilterChain{normalize}, "a\x1b[31mb\u202e", "a[31mb", false},
+		{"combining marks capped", FilterChain{normalize}, "e\u0301\u0301\u0301", "e\u0301", false},
+		{"lines capped", FilterChain{normalize}, "1\n2\n3", "1\n2", false},
+		{"empty after normalizing", FilterChain{normalize}, "\x00 \x07", "", true},
+		{"rejection stops the chain", FilterChain{reject, LinkFilter{}}, "darn www.example.com", "", true},
+		{"filters run in order", FilterChain{LinkFilter{}, redact}, "darn http://darn.com", "**** [link removed]", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.chain.Apply(tt.in)
+			var rejected *RejectedError
+			if errors.As(err, &rejected) != tt.rejected {
+				t.Fatalf("Apply(%q) error = %v, want rejected %v", tt.in, err, tt.rejected)
+			}
+			if got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewWordFilterInvalidExpression(t *testing.T) {
+	if _, err := NewWordFilter(nil, []string{"("}, false); err == nil {
+		t.Error("NewWordFilter accepted an invalid expression")
+	}
+}
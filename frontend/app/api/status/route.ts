@@ -1,21 +1,32 @@
 import { NextResponse } from "next/server";
-import { getRedis } from "@/lib/redis";
 
 export const dynamic = "force-dynamic"; // avoid static caching
 
+// Status reported by the Go server at /status
+type Status = {
+  online: number;
+  queued: number;
+  in_chat: number;
+  matches_last_hour: number;
+  version: string;
+};
+
 export async function GET() {
   try {
-    const redis = getRedis();
-    if (!redis) {
-      console.error("Redis client is not initialized");
+    const url = process.env.STATUS_URL;
+    if (!url) {
+      console.error("STATUS_URL is not defined in environment variables");
       return NextResponse.json({ active: 0 }, { status: 500 });
     }
-    const val = await redis.get("active"); // string or null
-    const count = val ? parseInt(val, 10) : 0;
+    const res = await fetch(url, { cache: "no-store", signal: AbortSignal.timeout(3000) });
+    if (!res.ok) {
+      throw new Error(`status endpoint returned ${res.status}`);
+    }
+    const status: Status = await res.json();
 
-    // Set a tiny cache so fast refreshes don’t stampede Redis (optional)
+    // Set a tiny cache so fast refreshes don’t stampede the server (optional)
     return NextResponse.json(
-      { active: Number.isFinite(count) ? count : 0 },
+      { active: status.online, ...status },
       {
         headers: {
           "Cache-Control": "public, max-age=5, s-maxage=5, stale-while-revalidate=30",
@@ -23,8 +34,8 @@ export async function GET() {
       }
     );
   } catch (err) {
-    // Don’t leak internals; just return 0 and a 200 to keep UI simple
-    console.error("Error fetching active count:", err);
+    // Don’t leak internals; just return 0 to keep UI simple
+    console.error("Error fetching status:", err);
     return NextResponse.json({ active: 0 }, { status: 500 });
   }
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// sshListening reports whether the SSH listener is bound and accepting connections.
+var sshListening atomic.Bool
+
+// healthzHandler reports that the process is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler reports whether this instance can take new users: the broker is
+// reachable, the SSH listener is bound, the matchmaking loop is alive and the
+// instance is not draining. Failing checks are listed in the response body.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	var problems []string
+	if err := globalBroker.Ping(); err != nil {
+		problems = append(problems, "broker: "+err.Error())
+	}
+	if !sshListening.Load() {
+		problems = append(problems, "ssh: not listening")
+	}
+	if !globalMatchmaker.Alive() {
+		problems = append(problems, "matchmaker: loop stalled")
+	}
+	if localSessions.isDraining() {
+		problems = append(problems, "draining")
+	}
+	if len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
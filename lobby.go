@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// lobbyRefreshInterval is how often the lobby reloads the online and queue counts.
+const lobbyRefreshInterval = 2 * time.Second
+
+const lobbyHelp = "enter start · ↑/↓ move · ←/→ change · ctrl+c quit"
+
+// chatMode is the kind of chat a user starts from the lobby.
+type chatMode int
+
+const (
+	modeRandom   chatMode = iota // One to one chat with anyone
+	modeInterest                 // One to one chat with someone sharing a tag
+	modeRoom                     // Group chat with everyone in a room
+	chatModes                    // Number of modes
+)
+
+func (c chatMode) String() string {
+	switch c {
+	case modeRandom:
+		return "Random chat"
+	case modeInterest:
+		return "Interest chat"
+	case modeRoom:
+		return "Group room"
+	}
+	return ""
+}
+
+// lobbyField is an editable row of the lobby form.
+type lobbyField int
+
+const (
+	fieldMode lobbyField = iota
+	fieldTags
+	fieldRoom
+	fieldNickname
+	fieldSettings
+)
+
+// lobbyStatsMsg carries fresh online and queue counts to the lobby. It belongs
+// to the lobby visit numbered seq; counts of earlier visits are dropped.
+type lobbyStatsMsg struct {
+	online int
+	queued int
+	seq    int
+}
+
+// lobbyRefreshMsg asks the lobby visit numbered seq to reload its counts.
+type lobbyRefreshMsg int
+
+// lobby is the screen where users choose how to chat before queuing.
+type lobby struct {
+	mode     chatMode        // Selected chat mode
+	focus    lobbyField      // Focused row
+	tags     textinput.Model // Interest tags, comma separated
+	room     textinput.Model // Group room name
+	nickname textinput.Model // Name shown to others
+	online   int             // Connected users, across every instance
+	queued   int             // Users waiting for a match
+	err      string          // Why the last attempt to start failed
+	seq      int             // Number of the current lobby visit
+}
+
+// newLobby creates the lobby form, starting in interest mode if tags are given.
+func newLobby(tags []string) lobby {
+	input := func(placeholder string, limit int) textinput.Model {
+		ti := textinput.New()
+		ti.Prompt = ""
+		ti.Placeholder = placeholder
+		ti.CharLimit = limit
+		ti.Width = 30
+		ti.Cursor.SetMode(cursor.CursorStatic)
+		return ti
+	}
+	l := lobby{
+		tags:     input("go, music", maxTags*(maxTagLength+2)),
+		room:     input(defaultRoom, maxRoomLength),
+		nickname: input("Stranger", maxNicknameLength),
+	}
+	if len(tags) > 0 {
+		l.mode = modeInterest
+		l.tags.SetValue(strings.Join(tags, ", "))
+	}
+	return l
+}
+
+// fields returns the rows shown for the selected mode.
+func (l lobby) fields() []lobbyField {
+	switch l.mode {
+	case modeInterest:
+		return []lobbyField{fieldMode, fieldTags, fieldNickname, fieldSettings}
+	case modeRoom:
+		return []lobbyField{fieldMode, fieldRoom, fieldNickname, fieldSettings}
+	}
+	return []lobbyField{fieldMode, fieldNickname, fieldSettings}
+}
+
+// move shifts the focus by delta rows, wrapping around.
+func (l lobby) move(delta int) lobby {
+	fields := l.fields()
+	i := 0
+	for j, f := range fields {
+		if f == l.focus {
+			i = j
+		}
+	}
+	l.focus = fields[(i+delta+len(fields))%len(fields)]
+	l.tags.Blur()
+	l.room.Blur()
+	l.nickname.Blur()
+	switch l.focus {
+	case fieldTags:
+		l.tags.Focus()
+	case fieldRoom:
+		l.room.Focus()
+	case fieldNickname:
+		l.nickname.Focus()
+	}
+	return l
+}
+
+// fetchLobbyStats loads the online and queue counts for lobby visit seq from
+// the status the instance last computed, as the status API does.
+func fetchLobbyStats(seq int) tea.Cmd {
+	return func() tea.Msg {
+		msg := lobbyStatsMsg{seq: seq}
+		if status := latestStatus.Load(); status != nil {
+			msg.online, msg.queued = status.Online, status.Queued
+		}
+		return msg
+	}
+}
+
+// openLobby shows the lobby and starts refreshing its counts.
+func (m model) openLobby() (model, tea.Cmd) {
+	m.uiState = StateUIMenu
+	m.textarea.Blur()
+	m.textarea.Reset()
+	m.lobby.err = ""
+	m.lobby.seq++
+	m.lobby = m.lobby.move(0)
+	return m, fetchLobbyStats(m.lobby.seq)
+}
+
+// handleLobbyKey edits the lobby form, starting the chosen chat on enter.
+func (m model) handleLobbyKey(msg tea.KeyMsg) (model, tea.Cmd) {
+	l := &m.lobby
+	if l.focus == fieldSettings {
+		switch msg.String() {
+		case "enter", "right", " ":
+			m.lobby.seq++ // Stop refreshing the counts
+			return m.openSettings(), nil
+		}
+	}
+	switch msg.String() {
+	case "enter":
+		return m.startChat()
+	case "up", "shift+tab":
+		m.lobby = l.move(-1)
+		return m, nil
+	case "down", "tab":
+		m.lobby = l.move(1)
+		return m, nil
+	case "left", "right", " ":
+		delta := 1
+		if msg.String() == "left" {
+			delta = -1
+		}
+		switch l.focus {
+		case fieldMode:
+			l.mode = (l.mode + chatMode(delta) + chatModes) % chatModes
+			l.err = ""
+			return m, nil
+		}
+	}
+	var cmd tea.Cmd
+	switch l.focus {
+	case fieldTags:
+		l.tags, cmd = l.tags.Update(msg)
+	case fieldRoom:
+		l.room, cmd = l.room.Update(msg)
+	case fieldNickname:
+		l.nickname, cmd = l.nickname.Update(msg)
+	}
+	return m, cmd
+}
+
+// startChat queues the user, or enters a group room, as chosen in the lobby.
+// On failure the lobby stays open and shows why.
+func (m model) startChat() (model, tea.Cmd) {
+	m.user.SetNickname(parseNickname(m.lobby.nickname.Value()))
+	if problem := m.join(); problem != "" {
+		m.lobby.err = problem
+		return m, nil
+	}
+	m.uiState = StateUIChat
+	m.lobby.seq++ // Stop refreshing the counts
+	cmd := m.textarea.Focus()
+	m.refreshViewport()
+	return m, cmd
+}
+
+// join queues the user, or enters a group room, in the mode chosen in the
+// lobby. It returns why the user could not join, or an empty string.
+func (m *model) join() string {
+	switch m.lobby.mode {
+	case modeRoom:
+		room := parseRoom(m.lobby.room.Value())
+		others, err := m.user.JoinRoom(room)
+		if err != nil {
+			return "Could not join the room. Try again later."
+		}
+		m.chatState = StateChatRoom
+		m.addSystem(fmt.Sprintf("You joined #%s with %d others. Send '\\q' to leave the room.", room, others))
+		return ""
+	case modeInterest:
+		tags := parseTags(m.lobby.tags.Value())
+		if len(tags) == 0 {
+			return "Add at least one interest to chat by interest."
+		}
+		m.user.tags = tags
+	default:
+		m.user.tags = nil
+	}
+	if err := globalMatchmaker.Enqueue(m.user); err != nil {
+		return "Could not enqueue. Try again later."
+	}
+	m.chatState = StateChatQueued
+	m.addSystem("Looking for someone to chat with... Send '\\q' to leave the queue.")
+	return ""
+}
+
+// lobbyView renders the lobby form with the live counts.
+func lobbyView(m model) string {
+	l := m.lobby
+	var b strings.Builder
+	b.WriteString(m.styles.title.Render(splashMessage) + "\n")
+	b.WriteString(fmt.Sprintf("%d online · %d waiting", l.online, l.queued) + "\n\n")
+	for _, f := range l.fields() {
+		var label, value string
+		switch f {
+		case fieldMode:
+			label, value = "Mode", "‹ "+l.mode.String()+" ›"
+		case fieldTags:
+			label, value = "Interests", l.tags.View()
+		case fieldRoom:
+			label, value = "Room", "#"+l.room.View()
+		case fieldNickname:
+			label, value = "Nickname", l.nickname.View()
+		case fieldSettings:
+			label, value = "Settings", "›"
+		}
+		label = fmt.Sprintf("%-14s", label)
+		if f == l.focus {
+			b.WriteString(m.styles.focus.Render("> "+label) + value + "\n")
+		} else {
+			b.WriteString("  " + label + value + "\n")
+		}
+	}
+	b.WriteString("\n" + lobbyHelp)
+	if l.err != "" {
+		b.WriteString("\n\n🚨 " + l.err)
+	}
+	return m.renderer.Place(
+		m.width, m.height,
+		lipgloss.Center, lipgloss.Center,
+		m.renderer.NewStyle().Align(lipgloss.Left).Render(b.String()),
+	)
+}
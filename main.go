@@ -6,11 +6,13 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
-	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/charmbracelet/ssh"
@@ -19,73 +21,164 @@ import (
 	"github.com/charmbracelet/wish/bubbletea"
 	"github.com/charmbracelet/wish/logging"
 	"github.com/joho/godotenv"
-	gossh "golang.org/x/crypto/ssh"
-)
-
-var (
-	host         = "localhost"
-	port         = "23234"
-	hostKeyPath  = ".ssh/id_ed25519"
-	shutdownTime = 30 * time.Second
+	"github.com/muesli/termenv"
+	"gopkg.in/yaml.v3"
 )
 
 var (
+	globalBroker     Broker
 	globalMatchmaker *Matchmaker
-	isDev            bool
+	messageFilters   FilterChain // Filters applied to every outgoing chat message
 )
 
 func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Warn("Could not load .env file", "error", err)
 	}
-	if os.Getenv("HOST") != "" {
-		host = os.Getenv("HOST")
+	cfg, args, printConfig, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration", "error", err)
+	}
+	config = cfg
+	if printConfig {
+		out, err := yaml.Marshal(config)
+		if err != nil {
+			log.Fatal("Could not print configuration", "error", err)
+		}
+		fmt.Print(string(out))
+		return
+	}
+	// Initialize global broker, using Redis unless running in memory only
+	if config.Broker == "memory" {
+		globalBroker = NewMemoryBroker()
+	} else {
+		globalBroker = NewRedisBroker(config.RedisURL)
 	}
-	if os.Getenv("PORT") != "" {
-		port = os.Getenv("PORT")
+	// Subcommands only talk to the broker, they do not start a server
+	if len(args) > 0 {
+		switch args[0] {
+		case "announce":
+			runAnnounce(strings.Join(args[1:], " "))
+		case "ban":
+			runBan(args[1:])
+		default:
+			log.Fatal("Unknown command", "command", args[0])
+		}
+		return
 	}
 	// Initialize global matchmaker
-	globalMatchmaker = NewMatchmaker()
-	isDev = os.Getenv("ENVIRONMENT") == "development"
+	globalMatchmaker = NewMatchmaker(globalBroker, config.Matchmaker)
+	// Clean up sessions left behind by dropped connections or crashed instances
+	go runReaper(globalBroker)
+	// Keep the status shown in the lobby and served at /status up to date
+	go runStatus(globalBroker)
+	// Serve metrics, health probes and status if an HTTP address is configured
+	if config.HTTPAddr != "" {
+		go serveHTTP(config.HTTPAddr)
+	}
+	if messageFilters, err = newMessageFilters(config.Filters); err != nil {
+		log.Fatal("Invalid message filter configuration", "error", err)
+	}
 
 	s, err := wish.NewServer(
-		wish.WithAddress(net.JoinHostPort(host, port)),
-		wish.WithHostKeyPath(hostKeyPath),
-		wish.WithPublicKeyAuth(func(_ ssh.Context, key ssh.PublicKey) bool {
-			// Check if the user is already in the matchmaker
-			parsedKey := string(gossh.MarshalAuthorizedKey(key))
-			hasUser, err := globalMatchmaker.HasUser(parsedKey)
-			if err != nil {
-				log.Error("Error checking user in matchmaker", "error", err)
-				return false
-			}
-			return isDev || !hasUser
-		}),
+		wish.WithAddress(net.JoinHostPort(config.Host, strconv.Itoa(config.Port))),
+		wish.WithHostKeyPath(config.HostKeyPath),
+		wish.WithPublicKeyAuth(publicKeyHandler),
+		wish.WithKeyboardInteractiveAuth(keyboardInteractiveHandler),
 		wish.WithMiddleware(
-			bubbletea.Middleware(teaHandler),
+			bubbletea.MiddlewareWithProgramHandler(programHandler, termenv.Ascii),
 			activeterm.Middleware(), // Bubble Tea apps usually require a PTY.
 			logging.Middleware(),
 		),
 	)
 	if err != nil {
-		log.Error("Could not start server", "error", err)
+		log.Fatal("Could not create server", "error", err)
+	}
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		log.Fatal("Could not start server", "error", err)
 	}
+	sshListening.Store(true)
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	log.Info("Starting SSH server", "host", host, "port", port, "dev", isDev)
+	log.Info("Starting SSH server", "host", config.Host, "port", config.Port, "dev", config.Dev)
 	go func() {
-		if err = s.ListenAndServe(); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
-			log.Error("Could not start server", "error", err)
-			done <- nil
+		if err := s.Serve(ln); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
+			log.Fatal("SSH server failed", "error", err)
 		}
 	}()
 
 	<-done
+	// End local sessions cleanly before the listener closes
+	drainSessions(config.DrainNotice)
 	log.Info("Stopping SSH server")
-	ctx, cancel := context.WithTimeout(context.Background(), shutdownTime)
+	sshListening.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
 	defer cancel()
 	if err := s.Shutdown(ctx); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
 		log.Error("Could not stop server", "error", err)
 	}
 }
+
+// runAnnounce sends an announcement to every session on every instance sharing
+// the broker, as in 'gomegle announce Restarting in 5 minutes'.
+func runAnnounce(message string) {
+	if message == "" {
+		log.Fatal("Usage: gomegle announce <message>")
+	}
+	if _, ok := globalBroker.(*MemoryBroker); ok {
+		log.Fatal("Announcements need the shared Redis broker, BROKER=memory reaches no sessions")
+	}
+	n, err := announce(globalBroker, message)
+	if err != nil {
+		log.Fatal("Could not send announcement", "error", err)
+	}
+	log.Info("Announcement sent", "sessions", n)
+}
+
+// runBan bans a key fingerprint, IP address or CIDR range on every instance
+// sharing the broker, as in 'gomegle ban 203.0.113.0/24 24h spam'. Sessions
+// already connected are not ended.
+func runBan(args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: gomegle ban <fingerprint|addr|cidr> [duration] [reason]")
+	}
+	if _, ok := globalBroker.(*MemoryBroker); ok {
+		log.Fatal("Bans need the shared Redis broker, BROKER=memory reaches no instance")
+	}
+	target := args[0]
+	duration, reason := parseBanArgs(args[1:])
+	var err error
+	if strings.HasPrefix(target, "SHA256:") {
+		err = banKey(globalBroker, target, reason, duration)
+	} else {
+		err = banAddr(globalBroker, target, reason, duration)
+	}
+	if err != nil {
+		log.Fatal("Could not ban", "target", target, "error", err)
+	}
+	log.Info("Banned", "target", target, "duration", duration, "reason", reason)
+}
+
+// newMessageFilters builds the outbound message filter chain. Links are
+// stripped unless allowed, and profanity matching the configured words or
+// regular expression is redacted, or rejected if configured.
+func newMessageFilters(cfg FilterConfig) (FilterChain, error) {
+	chain := FilterChain{NormalizeFilter{MaxLines: 5, MaxMarks: 2}}
+	if !cfg.AllowLinks {
+		chain = append(chain, LinkFilter{})
+	}
+	var expressions []string
+	if cfg.ProfanityRegex != "" {
+		expressions = []string{cfg.ProfanityRegex}
+	}
+	if len(cfg.ProfanityWords) > 0 || len(expressions) > 0 {
+		wf, err := NewWordFilter(cfg.ProfanityWords, expressions, cfg.ProfanityReject)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, wf)
+	}
+	return chain, nil
+}
@@ -1,57 +1,84 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/redis/go-redis/v9"
-	"google.golang.org/protobuf/proto"
+	"github.com/charmbracelet/log"
 )
 
-const lockKey = "match_lock"
+const (
+	lockKey        = "match_lock"
+	tagsPrefix     = "tags:"      // Interest tags of a queued user, comma separated
+	queuedAtPrefix = "queued_at:" // Time a user joined the queue, in unix milliseconds
+	recentPrefix   = "recent:"    // Recent partners, keyed as recent:<fingerprint>:<partner fingerprint>
+)
+
+// matchRetryInterval is how often the matchmaker re-evaluates the queue while
+// users are waiting for a tag match to fall back to random matching.
+const matchRetryInterval = time.Second
 
-// Lua: delete only if token matches
-var luaUnlock = redis.NewScript(`
-if redis.call("GET", KEYS[1]) == ARGV[1] then
-  return redis.call("DEL", KEYS[1])
-else
-  return 0
-end`)
+// matchLockTTL is how long the match lock is held without being extended. The
+// loop extends it while scanning the queue, so a long queue cannot outlast it
+// and let another instance match the same users.
+const matchLockTTL = 5 * time.Second
 
-// Lua: extend TTL only if token matches
-var luaExtend = redis.NewScript(`
-if redis.call("GET", KEYS[1]) == ARGV[1] then
-  return redis.call("PEXPIRE", KEYS[1], ARGV[2])
-else
-  return 0
-end`)
+// matchmakerStallTimeout is how long the matchmaking loop may go without making
+// progress before it is considered stuck.
+const matchmakerStallTimeout = 10 * time.Second
+
+// MatchmakerConfig holds the tunables of a Matchmaker.
+type MatchmakerConfig struct {
+	TagWait     time.Duration `yaml:"tag_wait"`     // How long to hold out for a shared tag before matching randomly
+	RecentTTL   time.Duration `yaml:"recent_ttl"`   // How long two users are remembered as recent partners
+	RematchWait time.Duration `yaml:"rematch_wait"` // How long to wait before rematching recent partners anyway
+}
 
 type Matchmaker struct {
-	lockToken string // Token to identify the lock owner
+	broker    Broker           // Backend holding the queue and user channels
+	lockToken string           // Token to identify the lock owner
+	config    MatchmakerConfig // Matching tunables
+	lastBeat  atomic.Int64     // When the matchmaking loop last made progress, in unix nanoseconds
+	lockedAt  time.Time        // When the match lock was last acquired or extended
 }
 
-// NewMatchmaker initializes a new Matchmaker instance and begins matching users.
-func NewMatchmaker() *Matchmaker {
-	b := make([]byte, 18)
-	if _, err := rand.Read(b); err != nil {
-		panic(err)
-	}
-	token := base64.RawURLEncoding.EncodeToString(b)
+// queuedUser is a snapshot of a user waiting in the queue.
+type queuedUser struct {
+	key         string    // Queue entry, the session ID of the user
+	fingerprint string    // Key fingerprint owning the session
+	tags        []string  // Interest tags of the user
+	queuedAt    time.Time // Time the user joined the queue
+}
+
+// NewMatchmaker initializes a new Matchmaker instance backed by the given broker
+// and begins matching users.
+func NewMatchmaker(b Broker, cfg MatchmakerConfig) *Matchmaker {
 	m := &Matchmaker{
-		lockToken: token,
+		broker:    b,
+		lockToken: newID(18),
+		config:    cfg,
 	}
+	m.beat()
 	go m.matchmakingLoop()
 	return m
 }
 
 func (m *Matchmaker) acquireLock() {
+	start := time.Now()
+	defer func() { lockLatency.Observe(time.Since(start).Seconds()) }()
 	for {
-		ok, err := rdb.SetNX(ctx, lockKey, m.lockToken, 5*time.Second).Result()
+		m.beat()
+		ok, err := m.broker.Lock(lockKey, m.lockToken, matchLockTTL)
 		if err != nil {
-			panic(err)
+			// The broker is unreachable, keep trying rather than taking the server down
+			log.Warn("Could not acquire match lock", "error", err)
+			time.Sleep(time.Second)
+			continue
 		}
 		if ok {
+			m.lockedAt = time.Now()
 			return // Lock acquired successfully
 		}
 		time.Sleep(100 * time.Millisecond) // Wait before retrying to acquire the lock
@@ -59,64 +86,215 @@ func (m *Matchmaker) acquireLock() {
 }
 
 func (m *Matchmaker) releaseLock() {
-	_, _ = luaUnlock.Run(ctx, rdb, []string{lockKey}, m.lockToken).Result()
+	_ = m.broker.Unlock(lockKey, m.lockToken)
+}
+
+// extendLock keeps holding the match lock for another matchLockTTL. It is
+// cheap to call often, the lock is only refreshed once a fifth of its TTL has
+// passed.
+func (m *Matchmaker) extendLock() {
+	if time.Since(m.lockedAt) < matchLockTTL/5 {
+		return
+	}
+	if err := m.broker.ExtendLock(lockKey, m.lockToken, matchLockTTL); err == nil {
+		m.lockedAt = time.Now()
+	}
+}
+
+// queuedUsers returns every user in the queue, oldest first.
+func (m *Matchmaker) queuedUsers() []queuedUser {
+	keys, err := m.broker.LRange(queueKey)
+	if err != nil {
+		return nil
+	}
+	// Fetch the fingerprint, tags and queue time of every user at once
+	fields := make([]string, 0, 3*len(keys))
+	for _, key := range keys {
+		fields = append(fields, sessionPrefix+key, tagsPrefix+key, queuedAtPrefix+key)
+	}
+	vals, err := m.broker.MGet(fields...)
+	if err != nil {
+		return nil
+	}
+	users := make([]queuedUser, 0, len(keys))
+	for i, key := range keys {
+		fp, tags, at := vals[3*i], vals[3*i+1], vals[3*i+2]
+		u := queuedUser{key: key, fingerprint: key, tags: parseTags(tags), queuedAt: time.Now()}
+		if fp != "" {
+			u.fingerprint = fp
+		}
+		if ms, err := strconv.ParseInt(at, 10, 64); err == nil {
+			u.queuedAt = time.UnixMilli(ms)
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+// acceptsRandom reports whether u may be matched with a user it shares no tags with.
+func (m *Matchmaker) acceptsRandom(u queuedUser) bool {
+	return len(u.tags) == 0 || time.Since(u.queuedAt) >= m.config.TagWait
+}
+
+// isRecentPair reports whether the keys of a and b were matched with each other
+// recently. Recent partners are only matched again once both have waited
+// RematchWait.
+func (m *Matchmaker) isRecentPair(a, b queuedUser) bool {
+	if time.Since(a.queuedAt) >= m.config.RematchWait && time.Since(b.queuedAt) >= m.config.RematchWait {
+		return false
+	}
+	for _, key := range []string{recentPrefix + a.fingerprint + ":" + b.fingerprint, recentPrefix + b.fingerprint + ":" + a.fingerprint} {
+		if ok, err := m.broker.Exists(key); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rememberPair records the keys a and b as recent partners of each other.
+func (m *Matchmaker) rememberPair(a, b string) {
+	if m.config.RecentTTL <= 0 {
+		return
+	}
+	_ = m.broker.Set(recentPrefix+a+":"+b, "1", m.config.RecentTTL)
+	_ = m.broker.Set(recentPrefix+b+":"+a, "1", m.config.RecentTTL)
+}
+
+// canPair reports whether a and b may be matched at all right now: neither has
+// blocked the other and they were not matched recently.
+func (m *Matchmaker) canPair(a, b queuedUser) bool {
+	m.extendLock() // Each candidate pair takes a few round trips
+	return !isBlocked(m.broker, a.fingerprint, b.fingerprint) && !m.isRecentPair(a, b)
+}
+
+// findPair picks the next two users to match. Pairs sharing a tag are preferred,
+// oldest first; otherwise two users that both accept a random match are paired.
+// Blocked and recent partners are skipped in both cases.
+func (m *Matchmaker) findPair(users []queuedUser) (u1, u2 queuedUser, shared []string, ok bool) {
+	for i := range users {
+		for j := i + 1; j < len(users); j++ {
+			if shared := sharedTags(users[i].tags, users[j].tags); len(shared) > 0 && m.canPair(users[i], users[j]) {
+				return users[i], users[j], shared, true
+			}
+		}
+	}
+	for i := range users {
+		if !m.acceptsRandom(users[i]) {
+			continue
+		}
+		for j := i + 1; j < len(users); j++ {
+			if m.acceptsRandom(users[j]) && m.canPair(users[i], users[j]) {
+				return users[i], users[j], nil, true
+			}
+		}
+	}
+	return queuedUser{}, queuedUser{}, nil, false
 }
 
 // matchUsers continuously checks the queue for users to match.
 func (m *Matchmaker) matchmakingLoop() {
-	pubsub := rdb.Subscribe(ctx, "user_joined")
-	ch := pubsub.Channel()
-	defer pubsub.Close() //nolint:all
+	sub := m.broker.Subscribe(userJoinedChannel)
+	ch := sub.Channel()
+	defer sub.Close() //nolint:all
 	m.acquireLock()
 	defer m.releaseLock()
 	for {
-		for rdb.LLen(ctx, "queue").Val() < 2 {
+		m.beat()
+		u1, u2, shared, ok := m.findPair(m.queuedUsers())
+		if !ok {
 			m.releaseLock()
-			<-ch
+			select {
+			case <-ch:
+			case <-time.After(matchRetryInterval):
+			}
 			m.acquireLock()
+			continue
+		}
+		// Either user may have left the queue since it was read, only pair them
+		// if both were still waiting and put back the one that was
+		n1, err1 := m.broker.LRem(queueKey, u1.key)
+		n2, err2 := m.broker.LRem(queueKey, u2.key)
+		if err1 != nil || err2 != nil || n1 != 1 || n2 != 1 {
+			if err1 == nil && n1 > 0 {
+				_ = m.broker.LPush(queueKey, u1.key)
+			}
+			if err2 == nil && n2 > 0 {
+				_ = m.broker.LPush(queueKey, u2.key)
+			}
+			continue
 		}
-		u1, _ := rdb.LPop(ctx, "queue").Result()
-		u2, _ := rdb.LPop(ctx, "queue").Result()
+
+		conversation := newID(12)
+		_ = setPair(m.broker, u1.key, u2.key, conversation)
 
 		joinMsg1 := ChatMsg{
 			Type:    ChatMsgTypeJoin,
-			Content: u2,
+			Content: u2.key,
+			Tags:    shared,
 		}
 		joinMsg2 := ChatMsg{
 			Type:    ChatMsgTypeJoin,
-			Content: u1,
+			Content: u1.key,
+			Tags:    shared,
 		}
-		data, _ := proto.Marshal(&joinMsg1)
-		rdb.Publish(ctx, "user:"+u1, data)
-		data, _ = proto.Marshal(&joinMsg2)
-		rdb.Publish(ctx, "user:"+u2, data)
+		data, _ := marshalFrame("", conversation, &joinMsg1)
+		_, _ = m.broker.Publish(userChannelPrefix+u1.key, data)
+		data, _ = marshalFrame("", conversation, &joinMsg2)
+		_, _ = m.broker.Publish(userChannelPrefix+u2.key, data)
 
-		rdb.SRem(ctx, "users", u1, u2) // Remove users from the active set
-		_, _ = luaExtend.Run(ctx, rdb, []string{lockKey}, m.lockToken, int64(5000*time.Millisecond)).Result()
+		_ = m.broker.SRem(usersKey, u1.key, u2.key) // Remove users from the active set
+		m.rememberPair(u1.fingerprint, u2.fingerprint)
+		matchesMade.Inc()
+		recordMatch(m.broker)
+		m.extendLock()
+		matchWait.Observe(time.Since(u1.queuedAt).Seconds())
+		matchWait.Observe(time.Since(u2.queuedAt).Seconds())
+		_ = m.broker.Del(tagsPrefix+u1.key, tagsPrefix+u2.key, queuedAtPrefix+u1.key, queuedAtPrefix+u2.key)
 	}
 }
 
+// beat records that the matchmaking loop is making progress.
+func (m *Matchmaker) beat() {
+	m.lastBeat.Store(time.Now().UnixNano())
+}
+
+// Alive reports whether the matchmaking loop has made progress recently.
+func (m *Matchmaker) Alive() bool {
+	return time.Since(time.Unix(0, m.lastBeat.Load())) < matchmakerStallTimeout
+}
+
 // Enqueue adds a user to the matchmaker queue
 func (m *Matchmaker) Enqueue(u *User) error {
-	pipe := rdb.TxPipeline()
-	pipe.RPush(ctx, "queue", u.pubKey)
-	pipe.SAdd(ctx, "users", u.pubKey)
-	pipe.Publish(ctx, "user_joined", "")
-	_, err := pipe.Exec(ctx)
+	if err := m.broker.Set(tagsPrefix+u.sessionID, strings.Join(u.tags, ","), 0); err != nil {
+		return err
+	}
+	if err := m.broker.Set(queuedAtPrefix+u.sessionID, strconv.FormatInt(time.Now().UnixMilli(), 10), 0); err != nil {
+		return err
+	}
+	if err := m.broker.RPush(queueKey, u.sessionID); err != nil {
+		return err
+	}
+	if err := m.broker.SAdd(usersKey, u.sessionID); err != nil {
+		return err
+	}
+	_, err := m.broker.Publish(userJoinedChannel, nil)
 	return err
 }
 
 // Dequeue removes a user from the queue and closes their send channel. If the user is
 // not found, this function is a no-op.
 func (m *Matchmaker) Dequeue(u *User) error {
-	pipe := rdb.TxPipeline()
-	pipe.LRem(ctx, "queue", 0, u.pubKey)
-	pipe.SRem(ctx, "users", u.pubKey)
-	_, err := pipe.Exec(ctx)
-	return err
+	if _, err := m.broker.LRem(queueKey, u.sessionID); err != nil {
+		return err
+	}
+	if err := m.broker.Del(tagsPrefix+u.sessionID, queuedAtPrefix+u.sessionID); err != nil {
+		return err
+	}
+	return m.broker.SRem(usersKey, u.sessionID)
 }
 
-// HasUser checks if a user with the given public key is currently in the matchmaker.
-func (m *Matchmaker) HasUser(key string) (bool, error) {
-	return rdb.SIsMember(ctx, "users", key).Result()
+// HasUser checks if the key with the given fingerprint currently has a session.
+func (m *Matchmaker) HasUser(fingerprint string) (bool, error) {
+	sessions, err := keySessions(m.broker, fingerprint)
+	return len(sessions) > 0, err
 }
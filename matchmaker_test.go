@@ -0,0 +1,111 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// testUser is a queued user of a findPair test, waiting for waited.
+type testUser struct {
+	key    string
+	tags   []string
+	waited time.Duration
+}
+
+func TestFindPair(t *testing.T) {
+	cfg := MatchmakerConfig{TagWait: 10 * time.Second, RecentTTL: time.Hour, RematchWait: time.Minute}
+	tests := []struct {
+		name   string
+		users  []testUser
+		setup  func(m *Matchmaker)
+		want   [2]string // Keys of the pair, empty if none
+		shared []string
+	}{
+		{
+			name:  "empty queue",
+			users: nil,
+		},
+		{
+			name:  "two untagged users",
+			users: []testUser{{key: "a"}, {key: "b"}},
+			want:  [2]string{"a", "b"},
+		},
+		{
+			name:   "shared tag preferred over older random match",
+			users:  []testUser{{key: "a"}, {key: "b", tags: []string{"go"}}, {key: "c", tags: []string{"art", "go"}}},
+			want:   [2]string{"b", "c"},
+			shared: []string{"go"},
+		},
+		{
+			name:  "tagged user holds out for a tag",
+			users: []testUser{{key: "a"}, {key: "b", tags: []string{"go"}}},
+		},
+		{
+			name:  "tagged user falls back after TagWait",
+			users: []testUser{{key: "a"}, {key: "b", tags: []string{"go"}, waited: 11 * time.Second}},
+			want:  [2]string{"a", "b"},
+		},
+		{
+			name:  "recent partners skipped",
+			users: []testUser{{key: "a"}, {key: "b"}, {key: "c"}},
+			setup: func(m *Matchmaker) { m.rememberPair("fp-a", "fp-b") },
+			want:  [2]string{"a", "c"},
+		},
+		{
+			name:  "recent partners rematched after RematchWait",
+			users: []testUser{{key: "a", waited: 2 * time.Minute}, {key: "b", waited: 2 * time.Minute}},
+			setup: func(m *Matchmaker) { m.rememberPair("fp-a", "fp-b") },
+			want:  [2]string{"a", "b"},
+		},
+		{
+			name:  "recent partners wait while one is new",
+			users: []testUser{{key: "a", waited: 2 * time.Minute}, {key: "b"}},
+			setup: func(m *Matchmaker) { m.rememberPair("fp-b", "fp-a") },
+		},
+		{
+			name:  "blocked stranger skipped",
+			users: []testUser{{key: "a"}, {key: "b"}, {key: "c"}},
+			setup: func(m *Matchmaker) { _ = blockKey(m.broker, "fp-b", "fp-a") },
+			want:  [2]string{"a", "c"},
+		},
+		{
+			name:   "blocked stranger skipped despite shared tag",
+			users:  []testUser{{key: "a", tags: []string{"go"}}, {key: "b", tags: []string{"go"}}, {key: "c", tags: []string{"go"}}},
+			setup:  func(m *Matchmaker) { _ = blockKey(m.broker, "fp-a", "fp-b") },
+			want:   [2]string{"a", "c"},
+			shared: []string{"go"},
+		},
+		{
+			name:  "blocked stranger never rematched",
+			users: []testUser{{key: "a", waited: time.Hour}, {key: "b", waited: time.Hour}},
+			setup: func(m *Matchmaker) { _ = blockKey(m.broker, "fp-a", "fp-b") },
+		},
+		{
+			name:  "different tags wait",
+			users: []testUser{{key: "a", tags: []string{"art"}}, {key: "b", tags: []string{"go"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Matchmaker{broker: NewMemoryBroker(), config: cfg}
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			users := make([]queuedUser, len(tt.users))
+			for i, u := range tt.users {
+				users[i] = queuedUser{key: u.key, fingerprint: "fp-" + u.key, tags: u.tags, queuedAt: time.Now().Add(-u.waited)}
+			}
+			u1, u2, shared, ok := m.findPair(users)
+			if got := [2]string{u1.key, u2.key}; got != tt.want {
+				t.Errorf("findPair = %q, want %q", got, tt.want)
+			}
+			if ok != (tt.want != [2]string{}) {
+				t.Errorf("findPair ok = %v", ok)
+			}
+			if !slices.Equal(shared, tt.shared) {
+				t.Errorf("shared = %q, want %q", shared, tt.shared)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBroker is a Broker that keeps all state in process memory. It is meant
+// for single-node deployments and local development without Redis.
+type MemoryBroker struct {
+	mu      sync.Mutex
+	strings map[string]string              // Plain key/value pairs
+	lists   map[string][]string            // Lists, head first
+	sets    map[string]map[string]struct{} // Sets of members
+	expiry  map[string]time.Time           // Expiry deadlines for any key
+	subs    map[string][]*memorySubscription
+}
+
+// NewMemoryBroker creates an empty in-memory Broker.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		strings: make(map[string]string),
+		lists:   make(map[string][]string),
+		sets:    make(map[string]map[string]struct{}),
+		expiry:  make(map[string]time.Time),
+		subs:    make(map[string][]*memorySubscription),
+	}
+}
+
+// expire drops key if its deadline has passed. The caller must hold b.mu.
+func (b *MemoryBroker) expire(key string) {
+	if deadline, ok := b.expiry[key]; ok && time.Now().After(deadline) {
+		b.del(key)
+	}
+}
+
+// del removes key of any type. The caller must hold b.mu.
+func (b *MemoryBroker) del(key string) {
+	delete(b.strings, key)
+	delete(b.lists, key)
+	delete(b.sets, key)
+	delete(b.expiry, key)
+}
+
+// exists reports whether key holds a value of any type. The caller must hold b.mu.
+func (b *MemoryBroker) exists(key string) bool {
+	b.expire(key)
+	_, isString := b.strings[key]
+	_, isList := b.lists[key]
+	_, isSet := b.sets[key]
+	return isString || isList || isSet
+}
+
+func (b *MemoryBroker) Get(key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	val, ok := b.strings[key]
+	if !ok {
+		return "", ErrNil
+	}
+	return val, nil
+}
+
+func (b *MemoryBroker) MGet(keys ...string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	vals := make([]string, len(keys))
+	for i, key := range keys {
+		b.expire(key)
+		vals[i] = b.strings[key]
+	}
+	return vals, nil
+}
+
+func (b *MemoryBroker) Set(key, value string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.del(key)
+	b.strings[key] = value
+	if ttl > 0 {
+		b.expiry[key] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Del(keys ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		b.del(key)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Exists(key string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exists(key), nil
+}
+
+func (b *MemoryBroker) Expire(key string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exists(key) {
+		b.expiry[key] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Incr(key string) (int64, error) {
+	return b.incrBy(key, 1)
+}
+
+func (b *MemoryBroker) Decr(key string) (int64, error) {
+	return b.incrBy(key, -1)
+}
+
+func (b *MemoryBroker) incrBy(key string, delta int64) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	var n int64
+	if val, ok := b.strings[key]; ok {
+		parsed, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		n = parsed
+	}
+	n += delta
+	b.strings[key] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+func (b *MemoryBroker) RPush(key string, values ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	b.lists[key] = append(b.lists[key], values...)
+	return nil
+}
+
+func (b *MemoryBroker) LPush(key string, values ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	head := slices.Clone(values)
+	slices.Reverse(head)
+	b.lists[key] = append(head, b.lists[key]...)
+	return nil
+}
+
+func (b *MemoryBroker) LPop(key string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	list := b.lists[key]
+	if len(list) == 0 {
+		return "", ErrNil
+	}
+	val := list[0]
+	if len(list) == 1 {
+		b.del(key)
+	} else {
+		b.lists[key] = list[1:]
+	}
+	return val, nil
+}
+
+func (b *MemoryBroker) LRem(key, value string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	before := len(b.lists[key])
+	list := slices.DeleteFunc(b.lists[key], func(v string) bool { return v == value })
+	if len(list) == 0 {
+		b.del(key)
+	} else {
+		b.lists[key] = list
+	}
+	return int64(before - len(list)), nil
+}
+
+func (b *MemoryBroker) LLen(key string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	return int64(len(b.lists[key])), nil
+}
+
+func (b *MemoryBroker) LRange(key string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	return slices.Clone(b.lists[key]), nil
+}
+
+func (b *MemoryBroker) SAdd(key string, members ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	set, ok := b.sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		b.sets[key] = set
+	}
+	for _, m := range members {
+		set[m] = struct{}{}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) SRem(key string, members ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	set := b.sets[key]
+	for _, m := range members {
+		delete(set, m)
+	}
+	if len(set) == 0 {
+		b.del(key)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) SIsMember(key, member string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	_, ok := b.sets[key][member]
+	return ok, nil
+}
+
+func (b *MemoryBroker) SMembers(key string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	members := make([]string, 0, len(b.sets[key]))
+	for m := range b.sets[key] {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// Publish delivers data to every subscriber of channel. Like Redis, a
+// subscriber that is not keeping up has the message dropped rather than
+// blocking the publisher.
+func (b *MemoryBroker) Publish(channel string, data []byte) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var n int64
+	for _, sub := range b.subs[channel] {
+		select {
+		case sub.ch <- &PubSubMsg{Channel: channel, Payload: data}:
+			n++
+		default:
+		}
+	}
+	return n, nil
+}
+
+func (b *MemoryBroker) Subscribe(channels ...string) Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &memorySubscription{
+		broker:   b,
+		channels: channels,
+		ch:       make(chan *PubSubMsg, 100),
+	}
+	for _, c := range channels {
+		b.subs[c] = append(b.subs[c], sub)
+	}
+	return sub
+}
+
+func (b *MemoryBroker) Lock(key, token string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exists(key) {
+		return false, nil
+	}
+	b.strings[key] = token
+	b.expiry[key] = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (b *MemoryBroker) Unlock(key, token string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	if b.strings[key] == token {
+		b.del(key)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) ExtendLock(key, token string, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	if b.strings[key] == token {
+		b.expiry[key] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+// TakeToken keeps the bucket at key as its token count and the unix nanosecond
+// time it was last updated, separated by a space.
+func (b *MemoryBroker) TakeToken(key string, capacity int, rate float64) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(key)
+	now := time.Now()
+	tokens := float64(capacity)
+	if val, ok := b.strings[key]; ok {
+		count, at, _ := strings.Cut(val, " ")
+		last, err := strconv.ParseInt(at, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		if tokens, err = strconv.ParseFloat(count, 64); err != nil {
+			return false, err
+		}
+		tokens = min(float64(capacity), tokens+now.Sub(time.Unix(0, last)).Seconds()*rate)
+	}
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	b.strings[key] = strconv.FormatFloat(tokens, 'g', -1, 64) + " " + strconv.FormatInt(now.UnixNano(), 10)
+	b.expiry[key] = now.Add(time.Duration((float64(capacity)-tokens)/rate*float64(time.Second)) + time.Second)
+	return allowed, nil
+}
+
+// Ping always succeeds, the broker lives in this process.
+func (b *MemoryBroker) Ping() error {
+	return nil
+}
+
+// memorySubscription is a Subscription to channels of a MemoryBroker.
+type memorySubscription struct {
+	broker   *MemoryBroker
+	channels []string
+	ch       chan *PubSubMsg
+	closed   bool
+}
+
+func (s *memorySubscription) Channel() <-chan *PubSubMsg {
+	return s.ch
+}
+
+func (s *memorySubscription) Close() error {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for _, c := range s.channels {
+		b.subs[c] = slices.DeleteFunc(b.subs[c], func(other *memorySubscription) bool { return other == s })
+		if len(b.subs[c]) == 0 {
+			delete(b.subs, c)
+		}
+	}
+	close(s.ch)
+	return nil
+}
@@ -1,14 +1,40 @@
 package main
 
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// protocolVersion is the Envelope version spoken by this server. Frames with any
+// other version are dropped.
+const protocolVersion = 1
+
 // // Enum for chat message types
 // type ChatMsgType int
 const (
-	ChatMsgTypeMessage ChatMsgType = ChatMsgType_MESSAGE // Regular message from a user
-	ChatMsgTypeJoin    ChatMsgType = ChatMsgType_JOIN    // User has been matched with another user
-	ChatMsgTypeLeave   ChatMsgType = ChatMsgType_LEAVE   // User has left the chat
-	ChatMsgTypeError   ChatMsgType = ChatMsgType_ERROR   // Error message
+	ChatMsgTypeMessage      ChatMsgType = ChatMsgType_MESSAGE      // Regular message from a user
+	ChatMsgTypeJoin         ChatMsgType = ChatMsgType_JOIN         // User has been matched with another user
+	ChatMsgTypeLeave        ChatMsgType = ChatMsgType_LEAVE        // User has left the chat
+	ChatMsgTypeError        ChatMsgType = ChatMsgType_ERROR        // Error message
+	ChatMsgTypeAck          ChatMsgType = ChatMsgType_ACK          // Acknowledges receipt of a message
+	ChatMsgTypeTyping       ChatMsgType = ChatMsgType_TYPING       // User is typing a message
+	ChatMsgTypeKick         ChatMsgType = ChatMsgType_KICK         // Server ended the user's session
+	ChatMsgTypeAnnouncement ChatMsgType = ChatMsgType_ANNOUNCEMENT // Notice from the operators
 )
 
+// marshalFrame wraps msg in an Envelope stamped with the current time and
+// encodes it for publishing. Server frames, such as JOIN, have an empty sender.
+func marshalFrame(sender, conversation string, msg *ChatMsg) ([]byte, error) {
+	return proto.Marshal(&Envelope{
+		Version:      protocolVersion,
+		SentAt:       time.Now().UnixMilli(),
+		Sender:       sender,
+		Conversation: conversation,
+		Msg:          msg,
+	})
+}
+
 //
 // // ChatMsg represents a message in the chat system
 // // It includes the type of message and its content.
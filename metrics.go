@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/charmbracelet/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Reasons a connection is rejected during authentication, used as the reason
+// label of authRejections.
+const (
+	rejectAdmin       = "admin"       // Key is not an admin key
+	rejectBanned      = "banned"      // Key or address is banned
+	rejectMaintenance = "maintenance" // Service is in maintenance mode
+	rejectDraining    = "draining"    // Instance is shutting down
+	rejectDuplicate   = "duplicate"   // Key already has a session
+	rejectError       = "error"       // Broker could not be reached
+)
+
+// Prometheus metrics of this instance. Counters only count events handled by
+// this instance, so they should be summed across instances; queue length is
+// shared and reported identically by every instance.
+var (
+	activeSessions = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gomegle_active_sessions",
+		Help: "Number of chat sessions hosted by this instance.",
+	}, func() float64 {
+		return float64(localSessions.len())
+	})
+	queueLength = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gomegle_queue_length",
+		Help: "Number of users waiting for a match.",
+	}, func() float64 {
+		n, err := globalBroker.LLen(queueKey)
+		if err != nil {
+			return 0
+		}
+		return float64(n)
+	})
+	matchesMade = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomegle_matches_total",
+		Help: "Number of pairs matched by this instance.",
+	})
+	matchWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gomegle_match_wait_seconds",
+		Help:    "Time users spent in the queue before being matched.",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+	})
+	messagesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomegle_messages_sent_total",
+		Help: "Number of chat messages sent by users of this instance.",
+	})
+	messagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomegle_messages_received_total",
+		Help: "Number of chat messages received by users of this instance.",
+	})
+	publishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomegle_publish_errors_total",
+		Help: "Number of frames that could not be delivered to a partner, by reason.",
+	}, []string{"reason"})
+	authRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomegle_auth_rejections_total",
+		Help: "Number of connections rejected during authentication, by reason.",
+	}, []string{"reason"})
+	lockLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gomegle_matchmaker_lock_seconds",
+		Help:    "Time taken to acquire the matchmaker lock.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+)
+
+// serveHTTP serves metrics, health probes and the status API on addr until the
+// process exits. The process exits if the address cannot be served.
+func serveHTTP(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/status", statusHandler)
+	log.Info("Starting HTTP server", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("Could not start HTTP server", "error", err)
+	}
+}
@@ -24,10 +24,14 @@ const (
 type ChatMsgType int32
 
 const (
-	ChatMsgType_MESSAGE ChatMsgType = 0
-	ChatMsgType_JOIN    ChatMsgType = 1
-	ChatMsgType_LEAVE   ChatMsgType = 2
-	ChatMsgType_ERROR   ChatMsgType = 3
+	ChatMsgType_MESSAGE      ChatMsgType = 0
+	ChatMsgType_JOIN         ChatMsgType = 1
+	ChatMsgType_LEAVE        ChatMsgType = 2
+	ChatMsgType_ERROR        ChatMsgType = 3
+	ChatMsgType_ACK          ChatMsgType = 4
+	ChatMsgType_TYPING       ChatMsgType = 5
+	ChatMsgType_KICK         ChatMsgType = 6
+	ChatMsgType_ANNOUNCEMENT ChatMsgType = 7
 )
 
 // Enum value maps for ChatMsgType.
@@ -37,12 +41,20 @@ var (
 		1: "JOIN",
 		2: "LEAVE",
 		3: "ERROR",
+		4: "ACK",
+		5: "TYPING",
+		6: "KICK",
+		7: "ANNOUNCEMENT",
 	}
 	ChatMsgType_value = map[string]int32{
-		"MESSAGE": 0,
-		"JOIN":    1,
-		"LEAVE":   2,
-		"ERROR":   3,
+		"MESSAGE":      0,
+		"JOIN":         1,
+		"LEAVE":        2,
+		"ERROR":        3,
+		"ACK":          4,
+		"TYPING":       5,
+		"KICK":         6,
+		"ANNOUNCEMENT": 7,
 	}
 )
 
@@ -77,6 +89,9 @@ type ChatMsg struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Type          ChatMsgType            `protobuf:"varint,1,opt,name=type,proto3,enum=ChatMsgType" json:"type,omitempty"`
 	Content       string                 `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	Tags          []string               `protobuf:"bytes,3,rep,name=tags,proto3" json:"tags,omitempty"`
+	Id            string                 `protobuf:"bytes,4,opt,name=id,proto3" json:"id,omitempty"`
+	Nickname      string                 `protobuf:"bytes,5,opt,name=nickname,proto3" json:"nickname,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -125,19 +140,130 @@ func (x *ChatMsg) GetContent() string {
 	return ""
 }
 
+func (x *ChatMsg) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *ChatMsg) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ChatMsg) GetNickname() string {
+	if x != nil {
+		return x.Nickname
+	}
+	return ""
+}
+
+type Envelope struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       uint32                 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	SentAt        int64                  `protobuf:"varint,2,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`
+	Sender        string                 `protobuf:"bytes,3,opt,name=sender,proto3" json:"sender,omitempty"`
+	Conversation  string                 `protobuf:"bytes,4,opt,name=conversation,proto3" json:"conversation,omitempty"`
+	Msg           *ChatMsg               `protobuf:"bytes,5,opt,name=msg,proto3" json:"msg,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Envelope) Reset() {
+	*x = Envelope{}
+	mi := &file_models_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Envelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Envelope) ProtoMessage() {}
+
+func (x *Envelope) ProtoReflect() protoreflect.Message {
+	mi := &file_models_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Envelope.ProtoReflect.Descriptor instead.
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return file_models_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Envelope) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Envelope) GetSentAt() int64 {
+	if x != nil {
+		return x.SentAt
+	}
+	return 0
+}
+
+func (x *Envelope) GetSender() string {
+	if x != nil {
+		return x.Sender
+	}
+	return ""
+}
+
+func (x *Envelope) GetConversation() string {
+	if x != nil {
+		return x.Conversation
+	}
+	return ""
+}
+
+func (x *Envelope) GetMsg() *ChatMsg {
+	if x != nil {
+		return x.Msg
+	}
+	return nil
+}
+
 var File_models_proto protoreflect.FileDescriptor
 
 const file_models_proto_rawDesc = "" +
 	"\n" +
-	"\fmodels.proto\"E\n" +
+	"\fmodels.proto\"\x85\x01\n" +
 	"\aChatMsg\x12 \n" +
 	"\x04type\x18\x01 \x01(\x0e2\f.ChatMsgTypeR\x04type\x12\x18\n" +
-	"\acontent\x18\x02 \x01(\tR\acontent*:\n" +
+	"\acontent\x18\x02 \x01(\tR\acontent\x12\x12\n" +
+	"\x04tags\x18\x03 \x03(\tR\x04tags\x12\x0e\n" +
+	"\x02id\x18\x04 \x01(\tR\x02id\x12\x1a\n" +
+	"\bnickname\x18\x05 \x01(\tR\bnickname\"\x95\x01\n" +
+	"\bEnvelope\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\rR\aversion\x12\x17\n" +
+	"\asent_at\x18\x02 \x01(\x03R\x06sentAt\x12\x16\n" +
+	"\x06sender\x18\x03 \x01(\tR\x06sender\x12\"\n" +
+	"\fconversation\x18\x04 \x01(\tR\fconversation\x12\x1a\n" +
+	"\x03msg\x18\x05 \x01(\v2\b.ChatMsgR\x03msg*k\n" +
 	"\vChatMsgType\x12\v\n" +
 	"\aMESSAGE\x10\x00\x12\b\n" +
 	"\x04JOIN\x10\x01\x12\t\n" +
 	"\x05LEAVE\x10\x02\x12\t\n" +
-	"\x05ERROR\x10\x03B\"Z github.com/johan253/gomegle/mainb\x06proto3"
+	"\x05ERROR\x10\x03\x12\a\n" +
+	"\x03ACK\x10\x04\x12\n" +
+	"\n" +
+	"\x06TYPING\x10\x05\x12\b\n" +
+	"\x04KICK\x10\x06\x12\x10\n" +
+	"\fANNOUNCEMENT\x10\aB\"Z github.com/johan253/gomegle/mainb\x06proto3"
 
 var (
 	file_models_proto_rawDescOnce sync.Once
@@ -152,18 +278,20 @@ func file_models_proto_rawDescGZIP() []byte {
 }
 
 var file_models_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_models_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_models_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_models_proto_goTypes = []any{
 	(ChatMsgType)(0), // 0: ChatMsgType
 	(*ChatMsg)(nil),  // 1: ChatMsg
+	(*Envelope)(nil), // 2: Envelope
 }
 var file_models_proto_depIdxs = []int32{
 	0, // 0: ChatMsg.type:type_name -> ChatMsgType
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1, // 1: Envelope.msg:type_name -> ChatMsg
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_models_proto_init() }
@@ -177,7 +305,7 @@ func file_models_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_models_proto_rawDesc), len(file_models_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	reportsKey     = "reports"  // List of JSON encoded reports awaiting review
+	blockedPrefix  = "blocked:" // Set of fingerprints blocked by a key fingerprint
+	transcriptSize = 20         // Number of recent chat lines kept for reports
+)
+
+// Report is an abuse report filed by a user against their chat partner.
+type Report struct {
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	Reporter   string    `json:"reporter"`   // Fingerprint of the reporting key
+	Reported   string    `json:"reported"`   // Fingerprint of the reported key
+	Reason     string    `json:"reason"`     // Reason given by the reporter
+	Transcript []string  `json:"transcript"` // Recent lines of the chat, oldest first
+}
+
+// fileReport adds a report to the moderation queue.
+func fileReport(b Broker, r Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.RPush(reportsKey, string(data))
+}
+
+// listReports returns every report in the moderation queue, oldest first.
+func listReports(b Broker) ([]Report, error) {
+	entries, err := b.LRange(reportsKey)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]Report, 0, len(entries))
+	for _, e := range entries {
+		var r Report
+		if err := json.Unmarshal([]byte(e), &r); err != nil {
+			continue // Skip malformed entries rather than hiding every report
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// blockKey adds blocked to the blocklist of the key fingerprint owner.
+func blockKey(b Broker, owner, blocked string) error {
+	return b.SAdd(blockedPrefix+owner, blocked)
+}
+
+// isBlocked reports whether either key fingerprint has blocked the other.
+func isBlocked(b Broker, first, second string) bool {
+	for _, pair := range [][2]string{{first, second}, {second, first}} {
+		if ok, err := b.SIsMember(blockedPrefix+pair[0], pair[1]); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
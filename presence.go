@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	presencePrefix = "presence:"   // Refreshed by each live session, expires when it dies
+	reaperLockKey  = "reaper_lock" // Held by the instance running the current reap
+)
+
+// Presence timings. A session refreshes its presence key every
+// presenceInterval; if it misses enough heartbeats for presenceTTL to pass, the
+// reaper treats it as dead.
+const (
+	presenceInterval = 10 * time.Second
+	presenceTTL      = 30 * time.Second
+	reaperInterval   = 15 * time.Second
+)
+
+// lostConnectionMessage is sent to the partner of a session ended by the reaper.
+const lostConnectionMessage = "Stranger's connection was lost"
+
+// heartbeat refreshes the presence key of a session until done is closed.
+func heartbeat(b Broker, sessionID string, done <-chan struct{}) {
+	ticker := time.NewTicker(presenceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Set(presencePrefix+sessionID, "1", presenceTTL); err != nil {
+				log.Warn("Could not refresh presence", "session", sessionID, "error", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// runReaper periodically ends sessions whose presence has expired, such as
+// those left behind by a crashed instance. Every instance runs a reaper, but
+// only one of them reaps during any given interval.
+func runReaper(b Broker) {
+	token := newID(18)
+	ticker := time.NewTicker(reaperInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ok, err := b.Lock(reaperLockKey, token, reaperInterval)
+		if err != nil {
+			log.Warn("Could not acquire reaper lock", "error", err)
+			continue
+		}
+		if !ok {
+			continue // Another instance is reaping
+		}
+		reap(b)
+	}
+}
+
+// reap ends every registered session that no longer has a presence key.
+func reap(b Broker) {
+	sessions, err := b.SMembers(sessionsKey)
+	if err != nil {
+		log.Warn("Could not list sessions", "error", err)
+		return
+	}
+	for _, sessionID := range sessions {
+		present, err := b.Exists(presencePrefix + sessionID)
+		if err != nil || present {
+			continue
+		}
+		log.Info("Reaping dead session", "session", sessionID)
+		if err := endSession(b, sessionID, lostConnectionMessage); err != nil {
+			log.Warn("Could not reap session", "session", sessionID, "error", err)
+		}
+	}
+}
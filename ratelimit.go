@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const rateLimitPrefix = "rate:" // Shared per key token buckets
+
+// Message rate limits. Each session may send bursts of up to rateLimitBurst
+// messages, refilled at rateLimitRate per second. When shared, the same budget
+// is enforced per key across every instance.
+const (
+	rateLimitBurst = 5
+	rateLimitRate  = 1.0
+)
+
+// Flood protection. A session that hits its rate limit maxStrikes times, with
+// less than strikeReset between violations, is disconnected.
+const (
+	maxStrikes  = 5
+	strikeReset = 30 * time.Second
+)
+
+// RateLimiter decides whether another message may be sent.
+type RateLimiter interface {
+	Allow() bool
+}
+
+// TokenBucket is an in-process RateLimiter that holds up to capacity tokens,
+// refilled continuously at rate tokens per second.
+type TokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewTokenBucket creates a full token bucket.
+func NewTokenBucket(capacity int, rate float64) *TokenBucket {
+	return &TokenBucket{
+		capacity: float64(capacity),
+		rate:     rate,
+		tokens:   float64(capacity),
+		last:     time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket if one is available.
+func (tb *TokenBucket) Allow() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := time.Now()
+	tb.tokens = min(tb.capacity, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// BrokerLimiter is a RateLimiter shared through a Broker, a token bucket like
+// TokenBucket kept under one key regardless of the instance sending.
+type BrokerLimiter struct {
+	broker   Broker
+	key      string
+	capacity int
+	rate     float64
+}
+
+// NewBrokerLimiter creates a limiter keeping its token bucket under key in the
+// broker.
+func NewBrokerLimiter(b Broker, key string, capacity int, rate float64) *BrokerLimiter {
+	return &BrokerLimiter{broker: b, key: key, capacity: capacity, rate: rate}
+}
+
+// Allow takes a token from the shared bucket. If the broker is unreachable the
+// message is allowed rather than silencing every user.
+func (bl *BrokerLimiter) Allow() bool {
+	ok, err := bl.broker.TakeToken(bl.key, bl.capacity, bl.rate)
+	return ok || err != nil
+}
+
+// newRateLimiter creates the message rate limiter of a session for the key with
+// the given fingerprint, shared across instances if configured.
+func newRateLimiter(b Broker, fingerprint string) RateLimiter {
+	if config.SharedRateLimit {
+		return NewBrokerLimiter(b, rateLimitPrefix+fingerprint, rateLimitBurst, rateLimitRate)
+	}
+	return NewTokenBucket(rateLimitBurst, rateLimitRate)
+}
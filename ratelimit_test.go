@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiters(t *testing.T) {
+	limiters := []struct {
+		name string
+		new  func(capacity int, rate float64) RateLimiter
+	}{
+		{"token bucket", func(capacity int, rate float64) RateLimiter {
+			return NewTokenBucket(capacity, rate)
+		}},
+		{"broker limiter", func(capacity int, rate float64) RateLimiter {
+			return NewBrokerLimiter(NewMemoryBroker(), "rate:test", capacity, rate)
+		}},
+	}
+	tests := []struct {
+		name     string
+		capacity int
+		rate     float64
+		first    int           // Messages sent at once
+		wait     time.Duration // Pause before the last message
+		allowed  int           // How many of the first messages are allowed
+		last     bool          // Whether the last message is allowed
+	}{
+		{"burst within capacity", 3, 1, 3, 0, 3, false},
+		{"burst over capacity", 3, 1, 5, 0, 3, false},
+		{"refilled", 2, 100, 2, 20 * time.Millisecond, 2, true},
+		{"not yet refilled", 2, 1, 2, 20 * time.Millisecond, 2, false},
+	}
+	for _, l := range limiters {
+		for _, tt := range tests {
+			t.Run(l.name+"/"+tt.name, func(t *testing.T) {
+				rl := l.new(tt.capacity, tt.rate)
+				allowed := 0
+				for range tt.first {
+					if rl.Allow() {
+						allowed++
+					}
+				}
+				if allowed != tt.allowed {
+					t.Errorf("allowed %d of %d, want %d", allowed, tt.first, tt.allowed)
+				}
+				time.Sleep(tt.wait)
+				if got := rl.Allow(); got != tt.last {
+					t.Errorf("last Allow = %v, want %v", got, tt.last)
+				}
+			})
+		}
+	}
+}
+
+func TestBrokerLimiterShared(t *testing.T) {
+	b := NewMemoryBroker()
+	first := NewBrokerLimiter(b, "rate:key", 2, 1)
+	second := NewBrokerLimiter(b, "rate:key", 2, 1)
+	other := NewBrokerLimiter(b, "rate:other", 2, 1)
+	if !first.Allow() || !second.Allow() {
+		t.Fatal("bucket should start full")
+	}
+	if first.Allow() || second.Allow() {
+		t.Error("limiters of one key should share a bucket")
+	}
+	if !other.Allow() {
+		t.Error("limiters of another key should have their own bucket")
+	}
+}
@@ -2,15 +2,240 @@ package main
 
 import (
 	"context"
-	"os"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 var ctx = context.Background()
 
-var url = os.Getenv("REDIS_URL")
+// pingTimeout bounds how long Ping waits for Redis to answer.
+const pingTimeout = 2 * time.Second
 
-var rdb = redis.NewClient(&redis.Options{
-	Addr: url, // Adjust the address as needed
-})
+// Lua: delete only if token matches
+var luaUnlock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+else
+  return 0
+end`)
+
+// Lua: extend TTL only if token matches
+var luaExtend = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+else
+  return 0
+end`)
+
+// Lua: take a token from a bucket of ARGV[1] tokens refilled at ARGV[2] per
+// second, timed by the Redis clock so every instance agrees
+var luaTakeToken = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local clock = redis.call("TIME")
+local now = tonumber(clock[1]) + tonumber(clock[2]) / 1000000
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate * 1000) + 1000)
+return allowed`)
+
+// RedisBroker is a Broker backed by a Redis server, allowing several GoMegle
+// instances to share one queue.
+type RedisBroker struct {
+	rdb *redis.Client
+}
+
+// NewRedisBroker creates a Broker connected to the Redis server at addr.
+func NewRedisBroker(addr string) *RedisBroker {
+	return &RedisBroker{
+		rdb: redis.NewClient(&redis.Options{
+			Addr: addr,
+		}),
+	}
+}
+
+func (b *RedisBroker) Get(key string) (string, error) {
+	val, err := b.rdb.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNil
+	}
+	return val, err
+}
+
+func (b *RedisBroker) MGet(keys ...string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	res, err := b.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]string, len(res))
+	for i, v := range res {
+		vals[i], _ = v.(string) // Missing keys come back as nil
+	}
+	return vals, nil
+}
+
+func (b *RedisBroker) Set(key, value string, ttl time.Duration) error {
+	return b.rdb.Set(ctx, key, value, ttl).Err()
+}
+
+func (b *RedisBroker) Del(keys ...string) error {
+	return b.rdb.Del(ctx, keys...).Err()
+}
+
+func (b *RedisBroker) Exists(key string) (bool, error) {
+	n, err := b.rdb.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+func (b *RedisBroker) Expire(key string, ttl time.Duration) error {
+	return b.rdb.Expire(ctx, key, ttl).Err()
+}
+
+func (b *RedisBroker) Incr(key string) (int64, error) {
+	return b.rdb.Incr(ctx, key).Result()
+}
+
+func (b *RedisBroker) Decr(key string) (int64, error) {
+	return b.rdb.Decr(ctx, key).Result()
+}
+
+func (b *RedisBroker) RPush(key string, values ...string) error {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return b.rdb.RPush(ctx, key, args...).Err()
+}
+
+func (b *RedisBroker) LPush(key string, values ...string) error {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return b.rdb.LPush(ctx, key, args...).Err()
+}
+
+func (b *RedisBroker) LPop(key string) (string, error) {
+	val, err := b.rdb.LPop(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNil
+	}
+	return val, err
+}
+
+func (b *RedisBroker) LRem(key, value string) (int64, error) {
+	return b.rdb.LRem(ctx, key, 0, value).Result()
+}
+
+func (b *RedisBroker) LLen(key string) (int64, error) {
+	return b.rdb.LLen(ctx, key).Result()
+}
+
+func (b *RedisBroker) LRange(key string) ([]string, error) {
+	return b.rdb.LRange(ctx, key, 0, -1).Result()
+}
+
+func (b *RedisBroker) SAdd(key string, members ...string) error {
+	args := make([]any, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return b.rdb.SAdd(ctx, key, args...).Err()
+}
+
+func (b *RedisBroker) SRem(key string, members ...string) error {
+	args := make([]any, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return b.rdb.SRem(ctx, key, args...).Err()
+}
+
+func (b *RedisBroker) SIsMember(key, member string) (bool, error) {
+	return b.rdb.SIsMember(ctx, key, member).Result()
+}
+
+func (b *RedisBroker) SMembers(key string) ([]string, error) {
+	return b.rdb.SMembers(ctx, key).Result()
+}
+
+func (b *RedisBroker) Publish(channel string, data []byte) (int64, error) {
+	return b.rdb.Publish(ctx, channel, data).Result()
+}
+
+func (b *RedisBroker) Subscribe(channels ...string) Subscription {
+	pubsub := b.rdb.Subscribe(ctx, channels...)
+	sub := &redisSubscription{
+		pubsub: pubsub,
+		ch:     make(chan *PubSubMsg),
+		done:   make(chan struct{}),
+	}
+	go sub.forward()
+	return sub
+}
+
+func (b *RedisBroker) Lock(key, token string, ttl time.Duration) (bool, error) {
+	return b.rdb.SetNX(ctx, key, token, ttl).Result()
+}
+
+func (b *RedisBroker) Unlock(key, token string) error {
+	return luaUnlock.Run(ctx, b.rdb, []string{key}, token).Err()
+}
+
+func (b *RedisBroker) ExtendLock(key, token string, ttl time.Duration) error {
+	return luaExtend.Run(ctx, b.rdb, []string{key}, token, ttl.Milliseconds()).Err()
+}
+
+func (b *RedisBroker) TakeToken(key string, capacity int, rate float64) (bool, error) {
+	n, err := luaTakeToken.Run(ctx, b.rdb, []string{key}, capacity, rate).Int()
+	return n == 1, err
+}
+
+func (b *RedisBroker) Ping() error {
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return b.rdb.Ping(pingCtx).Err()
+}
+
+// redisSubscription adapts a Redis PubSub to the Subscription interface.
+type redisSubscription struct {
+	pubsub *redis.PubSub
+	ch     chan *PubSubMsg
+	done   chan struct{}
+	once   sync.Once
+}
+
+// forward relays Redis messages until the underlying PubSub is closed.
+func (s *redisSubscription) forward() {
+	defer close(s.ch)
+	for msg := range s.pubsub.Channel() {
+		select {
+		case s.ch <- &PubSubMsg{Channel: msg.Channel, Payload: []byte(msg.Payload)}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *redisSubscription) Channel() <-chan *PubSubMsg {
+	return s.ch
+}
+
+func (s *redisSubscription) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return s.pubsub.Close()
+}
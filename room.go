@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Limits applied to group rooms and nicknames.
+const (
+	defaultRoom       = "general"
+	maxRoomLength     = 20
+	maxNicknameLength = 20
+)
+
+// parseRoom normalizes a group room name to lower case letters, digits and
+// dashes, falling back to defaultRoom if nothing is left.
+func parseRoom(s string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r == ' ' || r == '_':
+			return '-'
+		}
+		return -1
+	}, strings.ToLower(strings.TrimSpace(s)))
+	name = strings.Trim(name, "-")
+	if len(name) > maxRoomLength {
+		name = name[:maxRoomLength]
+	}
+	if name == "" {
+		return defaultRoom
+	}
+	return name
+}
+
+// parseNickname trims a nickname and drops control characters, keeping at most
+// maxNicknameLength characters. An empty nickname shows the user as a stranger.
+func parseNickname(s string) string {
+	name := []rune(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s)))
+	if len(name) > maxNicknameLength {
+		name = name[:maxNicknameLength]
+	}
+	return strings.TrimSpace(string(name))
+}
+
+// SetNickname sets the name shown to the people this user chats with.
+func (u *User) SetNickname(nickname string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.nickname = nickname
+}
+
+// Room returns the group room the user is in, empty if none.
+func (u *User) Room() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.room
+}
+
+// JoinRoom enters the named group room, announcing the user to the people in
+// it, and returns how many others are there. Room messages are published to
+// each member's own channel, so no further subscription is needed.
+func (u *User) JoinRoom(name string) (int, error) {
+	if err := u.broker.Set(sessionRoomPrefix+u.sessionID, name, 0); err != nil {
+		return 0, err
+	}
+	if err := u.broker.SAdd(roomPrefix+name, u.sessionID); err != nil {
+		return 0, err
+	}
+	u.mu.Lock()
+	u.room = name
+	nickname := u.nickname
+	u.mu.Unlock()
+	return u.broadcast(&ChatMsg{Type: ChatMsgTypeJoin, Nickname: nickname})
+}
+
+// LeaveRoom tells the people in the user's group room that the user left and
+// leaves it. It does nothing outside a room.
+func (u *User) LeaveRoom() error {
+	u.mu.Lock()
+	room, nickname := u.room, u.nickname
+	u.mu.Unlock()
+	if room == "" {
+		return nil
+	}
+	_, _ = u.broadcast(&ChatMsg{Type: ChatMsgTypeLeave, Nickname: nickname})
+	u.mu.Lock()
+	u.room = ""
+	u.mu.Unlock()
+	if err := u.broker.SRem(roomPrefix+room, u.sessionID); err != nil {
+		return err
+	}
+	return u.broker.Del(sessionRoomPrefix + u.sessionID)
+}
+
+// SendRoomMessage filters content and sends it to everyone else in the user's
+// group room, returning the content as sent. Room messages are not
+// acknowledged or retried.
+func (u *User) SendRoomMessage(content string) (string, error) {
+	content, err := u.filters.Apply(content)
+	if rejected := (*RejectedError)(nil); errors.As(err, &rejected) {
+		u.notify(rejected.Reason)
+	}
+	if err != nil {
+		return "", err
+	}
+	u.mu.Lock()
+	nickname := u.nickname
+	u.mu.Unlock()
+	messagesSent.Inc()
+	_, err = u.broadcast(&ChatMsg{Type: ChatMsgTypeMessage, Content: content, Nickname: nickname})
+	return content, err
+}
+
+// broadcast publishes msg to every other member of the user's group room and
+// returns how many received it. Members nobody is listening for are left
+// behind by sessions that ended uncleanly and are removed from the room.
+func (u *User) broadcast(msg *ChatMsg) (int, error) {
+	room := u.Room()
+	if room == "" {
+		return 0, nil
+	}
+	members, err := u.broker.SMembers(roomPrefix + room)
+	if err != nil {
+		return 0, err
+	}
+	data, err := marshalFrame(u.sessionID, roomPrefix+room, msg)
+	if err != nil {
+		return 0, err
+	}
+	received := 0
+	for _, member := range members {
+		if member == u.sessionID {
+			continue
+		}
+		n, err := u.broker.Publish(userChannelPrefix+member, data)
+		if err != nil {
+			publishErrors.WithLabelValues("broker").Inc()
+			return received, err
+		}
+		if n == 0 {
+			_ = u.broker.SRem(roomPrefix+room, member)
+			continue
+		}
+		received++
+	}
+	return received, nil
+}
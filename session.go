@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+const (
+	sessionsKey        = "sessions"           // Set of every live session ID
+	sessionPrefix      = "session:"           // Maps a session ID to its key fingerprint
+	keySessionsPrefix  = "key_sessions:"      // Set of live session IDs per key fingerprint
+	pairPrefix         = "pair:"              // Maps a matched session ID to its partner's
+	conversationPrefix = "conversation:"      // Maps a matched session ID to its conversation ID
+	endedPrefix        = "ended:"             // Marks a session whose cleanup has started
+	instancesKey       = "instances"          // Set of instance IDs that have hosted sessions
+	instancePrefix     = "instance:"          // Maps a session ID to the instance hosting it
+	instanceSessions   = "instance_sessions:" // Set of live session IDs per instance
+	roomPrefix         = "room:"              // Set of the session IDs in a group room, by room name
+	sessionRoomPrefix  = "session_room:"      // Maps a session ID to the group room it is in
+)
+
+// newID returns a random URL safe identifier built from n random bytes.
+func newID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// registerSession records a new session for the key with the given fingerprint
+// on this instance, marks it present and counts it as active.
+func registerSession(b Broker, sessionID, fingerprint string) error {
+	if err := b.Set(sessionPrefix+sessionID, fingerprint, 0); err != nil {
+		return err
+	}
+	if err := b.Set(instancePrefix+sessionID, config.Instance, 0); err != nil {
+		return err
+	}
+	if err := b.SAdd(instanceSessions+config.Instance, sessionID); err != nil {
+		return err
+	}
+	if err := b.SAdd(instancesKey, config.Instance); err != nil {
+		return err
+	}
+	if err := b.Set(presencePrefix+sessionID, "1", presenceTTL); err != nil {
+		return err
+	}
+	if err := b.SAdd(keySessionsPrefix+fingerprint, sessionID); err != nil {
+		return err
+	}
+	if err := b.SAdd(sessionsKey, sessionID); err != nil {
+		return err
+	}
+	_, err := b.Incr(activeKey)
+	return err
+}
+
+// endSession removes every trace of a session from the broker: its queue entry,
+// pairing and registration. A matched partner is sent a LEAVE with the given
+// reason. Only the first call for a session has any effect, so the session
+// itself and the reaper may both safely try to end it.
+func endSession(b Broker, sessionID, reason string) error {
+	first, err := b.Lock(endedPrefix+sessionID, "1", presenceTTL)
+	if err != nil || !first {
+		return err
+	}
+	if _, err := b.LRem(queueKey, sessionID); err != nil {
+		return err
+	}
+	if err := b.SRem(usersKey, sessionID); err != nil {
+		return err
+	}
+	if partner, err := b.Get(pairPrefix + sessionID); err == nil {
+		// Send the LEAVE on behalf of the ended session so the partner accepts it
+		conversation, _ := b.Get(conversationPrefix + sessionID)
+		data, _ := marshalFrame(sessionID, conversation, &ChatMsg{
+			Type:    ChatMsgTypeLeave,
+			Content: reason,
+		})
+		_, _ = b.Publish(userChannelPrefix+partner, data)
+		_ = clearPair(b, partner)
+	}
+	keys := []string{
+		pairPrefix + sessionID,
+		conversationPrefix + sessionID,
+		presencePrefix + sessionID,
+		tagsPrefix + sessionID,
+		queuedAtPrefix + sessionID,
+		sessionPrefix + sessionID,
+		instancePrefix + sessionID,
+		sessionRoomPrefix + sessionID,
+	}
+	if fingerprint, err := sessionFingerprint(b, sessionID); err == nil {
+		if err := b.SRem(keySessionsPrefix+fingerprint, sessionID); err != nil {
+			return err
+		}
+	}
+	if room, err := b.Get(sessionRoomPrefix + sessionID); err == nil {
+		if err := b.SRem(roomPrefix+room, sessionID); err != nil {
+			return err
+		}
+	}
+	if instance, err := b.Get(instancePrefix + sessionID); err == nil {
+		if err := b.SRem(instanceSessions+instance, sessionID); err != nil {
+			return err
+		}
+	}
+	if err := b.Del(keys...); err != nil {
+		return err
+	}
+	if err := b.SRem(sessionsKey, sessionID); err != nil {
+		return err
+	}
+	_, err = b.Decr(activeKey)
+	return err
+}
+
+// sessionFingerprint returns the key fingerprint owning the given session.
+func sessionFingerprint(b Broker, sessionID string) (string, error) {
+	return b.Get(sessionPrefix + sessionID)
+}
+
+// keySessions returns the live session IDs of the key with the given fingerprint.
+func keySessions(b Broker, fingerprint string) ([]string, error) {
+	return b.SMembers(keySessionsPrefix + fingerprint)
+}
+
+// setPair records two sessions as matched with each other in a conversation.
+func setPair(b Broker, sessionID, partnerID, conversation string) error {
+	for _, kv := range [][2]string{
+		{pairPrefix + sessionID, partnerID},
+		{pairPrefix + partnerID, sessionID},
+		{conversationPrefix + sessionID, conversation},
+		{conversationPrefix + partnerID, conversation},
+	} {
+		if err := b.Set(kv[0], kv[1], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearPair forgets the pairing of the given sessions.
+func clearPair(b Broker, sessionIDs ...string) error {
+	keys := make([]string, 0, 2*len(sessionIDs))
+	for _, id := range sessionIDs {
+		keys = append(keys, pairPrefix+id, conversationPrefix+id)
+	}
+	return b.Del(keys...)
+}
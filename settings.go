@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	prefsPrefix = "prefs:"            // JSON encoded Preferences of a key fingerprint
+	prefsTTL    = 90 * 24 * time.Hour // How long unused preferences are kept
+)
+
+const settingsHelp = "↑/↓ move · ←/→ change · esc back"
+
+// Preferences are the settings a user keeps across sessions, stored per key
+// fingerprint.
+type Preferences struct {
+	AutoRequeue bool   `json:"auto_requeue"` // Queue again when a chat ends
+	Timestamps  bool   `json:"timestamps"`   // Show the time of chat messages
+	Theme       string `json:"theme"`        // Name of the color theme
+	Bell        bool   `json:"bell"`         // Ring the terminal bell on new messages
+	Compact     bool   `json:"compact"`      // Use a single line message input
+}
+
+// defaultPreferences returns the preferences of a key that never saved any.
+func defaultPreferences() Preferences {
+	return Preferences{Theme: themes[0].Name}
+}
+
+// loadPreferences returns the preferences saved for the key with the given
+// fingerprint, or the defaults if there are none. Loading keeps them for
+// another prefsTTL.
+func loadPreferences(b Broker, fingerprint string) (Preferences, error) {
+	prefs := defaultPreferences()
+	data, err := b.Get(prefsPrefix + fingerprint)
+	if errors.Is(err, ErrNil) {
+		return prefs, nil
+	}
+	if err != nil {
+		return prefs, err
+	}
+	if err := json.Unmarshal([]byte(data), &prefs); err != nil {
+		return defaultPreferences(), err
+	}
+	if _, ok := themeByName(prefs.Theme); !ok {
+		prefs.Theme = themes[0].Name // The theme was removed
+	}
+	_ = b.Expire(prefsPrefix+fingerprint, prefsTTL)
+	return prefs, nil
+}
+
+// savePreferences stores the preferences of the key with the given fingerprint.
+func savePreferences(b Broker, fingerprint string, prefs Preferences) error {
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	return b.Set(prefsPrefix+fingerprint, string(data), prefsTTL)
+}
+
+// applyPreferences restyles the model for its preferences and fits the input to
+// the compact setting.
+func (m model) applyPreferences() model {
+	return m.applyTheme(m.prefs.Theme).resize()
+}
+
+// stamp returns the time prefix of a chat message sent or received at t, empty
+// unless timestamps are enabled.
+func (m model) stamp(t time.Time) string {
+	if !m.prefs.Timestamps {
+		return ""
+	}
+	return m.styles.dim.Render(t.Format("15:04")) + " "
+}
+
+// ringBell rings the terminal bell if the user enabled it.
+func (m model) ringBell() tea.Cmd {
+	if !m.prefs.Bell {
+		return nil
+	}
+	return func() tea.Msg {
+		_, _ = io.WriteString(m.out, "\a")
+		return nil
+	}
+}
+
+// setting is a row of the settings screen.
+type setting int
+
+const (
+	settingAutoRequeue setting = iota
+	settingTimestamps
+	settingTheme
+	settingBell
+	settingCompact
+	settingCount // Number of settings
+)
+
+// openSettings shows the settings screen, returning to the current screen when
+// it is closed.
+func (m model) openSettings() model {
+	m.settingsBack = m.uiState
+	m.settingsFocus = settingAutoRequeue
+	m.uiState = StateUISettings
+	m.textarea.Blur()
+	return m
+}
+
+// closeSettings saves the preferences and returns to the previous screen.
+func (m model) closeSettings() (model, tea.Cmd) {
+	if err := savePreferences(globalBroker, m.user.fingerprint, m.prefs); err != nil {
+		m.addError("Error: Could not save settings. They apply to this session only.")
+	}
+	if m.settingsBack == StateUIMenu {
+		return m.openLobby()
+	}
+	m.uiState = m.settingsBack
+	cmd := m.textarea.Focus()
+	m.textarea.Reset()
+	m.refreshViewport()
+	return m, cmd
+}
+
+// handleSettingsKey moves between and changes settings.
+func (m model) handleSettingsKey(msg tea.KeyMsg) (model, tea.Cmd) {
+	delta := 1
+	switch msg.String() {
+	case "esc", "q":
+		return m.closeSettings()
+	case "up", "shift+tab":
+		m.settingsFocus = (m.settingsFocus + settingCount - 1) % settingCount
+		return m, nil
+	case "down", "tab":
+		m.settingsFocus = (m.settingsFocus + 1) % settingCount
+		return m, nil
+	case "left":
+		delta = -1
+	case "right", " ", "enter":
+	default:
+		return m, nil
+	}
+	switch m.settingsFocus {
+	case settingAutoRequeue:
+		m.prefs.AutoRequeue = !m.prefs.AutoRequeue
+	case settingTimestamps:
+		m.prefs.Timestamps = !m.prefs.Timestamps
+	case settingTheme:
+		names := themeNames()
+		i := slices.Index(names, m.prefs.Theme)
+		m.prefs.Theme = names[(i+delta+len(names))%len(names)]
+	case settingBell:
+		m.prefs.Bell = !m.prefs.Bell
+	case settingCompact:
+		m.prefs.Compact = !m.prefs.Compact
+	}
+	return m.applyPreferences(), nil
+}
+
+// settingsView renders the settings screen.
+func settingsView(m model) string {
+	check := func(on bool) string {
+		if on {
+			return "[x]"
+		}
+		return "[ ]"
+	}
+	var b strings.Builder
+	b.WriteString(m.styles.title.Render("Settings") + "\n\n")
+	for s := range settingCount {
+		var label, value, about string
+		switch s {
+		case settingAutoRequeue:
+			label, value, about = "Auto-requeue", check(m.prefs.AutoRequeue), "Queue again when a chat ends"
+		case settingTimestamps:
+			label, value, about = "Timestamps", check(m.prefs.Timestamps), "Show the time of chat messages"
+		case settingTheme:
+			label, value, about = "Theme", "‹ "+m.prefs.Theme+" ›", "Colors of names and titles"
+		case settingBell:
+			label, value, about = "Bell", check(m.prefs.Bell), "Ring the terminal bell on new messages"
+		case settingCompact:
+			label, value, about = "Compact mode", check(m.prefs.Compact), "Single line message input"
+		}
+		line := fmt.Sprintf("%-14s%-12s", label, value)
+		if s == m.settingsFocus {
+			b.WriteString(m.styles.focus.Render("> "+line) + m.styles.dim.Render(about) + "\n")
+		} else {
+			b.WriteString("  " + line + m.styles.dim.Render(about) + "\n")
+		}
+	}
+	b.WriteString("\n" + settingsHelp)
+	return m.renderer.Place(
+		m.width, m.height,
+		lipgloss.Center, lipgloss.Center,
+		m.renderer.NewStyle().Align(lipgloss.Left).Render(b.String()),
+	)
+}
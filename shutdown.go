@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish/bubbletea"
+)
+
+// restartingMessage is shown to users connecting while this instance drains.
+const restartingMessage = "This server is restarting, please reconnect in a moment."
+
+// shutdownMsg tells a chat session that the server shuts down at the given time.
+type shutdownMsg time.Time
+
+// localSession is a chat session hosted by this instance.
+type localSession struct {
+	user    *User        // The session's user
+	program *tea.Program // The session's Bubble Tea program
+}
+
+// sessionRegistry tracks the chat sessions hosted by this instance so they can
+// be drained on shutdown.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]localSession // Live sessions, by session ID
+	draining bool                    // Whether the instance is shutting down
+}
+
+// localSessions holds every chat session hosted by this instance.
+var localSessions = &sessionRegistry{sessions: make(map[string]localSession)}
+
+// add registers a session. It reports false if the instance is draining and
+// the session should not start.
+func (r *sessionRegistry) add(s localSession) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.draining {
+		return false
+	}
+	r.sessions[s.user.sessionID] = s
+	return true
+}
+
+// remove forgets the session with the given ID.
+func (r *sessionRegistry) remove(sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, sessionID)
+}
+
+// isDraining reports whether the instance is shutting down.
+func (r *sessionRegistry) isDraining() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.draining
+}
+
+// len returns the number of live sessions.
+func (r *sessionRegistry) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sessions)
+}
+
+// drain stops new sessions from being added and returns the live ones.
+func (r *sessionRegistry) drain() []localSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.draining = true
+	sessions := make([]localSession, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// programHandler creates the Bubble Tea program of a new SSH session and
+// registers chat sessions with localSessions.
+func programHandler(s ssh.Session) *tea.Program {
+	m, opts := teaHandler(s)
+	if m == nil {
+		return nil
+	}
+	// The server handles signals itself, draining sessions before shutdown
+	opts = append(opts, tea.WithoutSignalHandler())
+	p := tea.NewProgram(m, append(opts, bubbletea.MakeOptions(s)...)...)
+	chat, ok := m.(model)
+	if !ok {
+		return p // The admin console is not drained
+	}
+	if !localSessions.add(localSession{user: chat.user, program: p}) {
+		_ = chat.user.Close()
+		return nil
+	}
+	go func() {
+		<-s.Context().Done()
+		localSessions.remove(chat.user.sessionID)
+	}()
+	return p
+}
+
+// drainSessions shows every local session a countdown of notice, then ends
+// them: matched partners are sent a LEAVE, queued users are dequeued and the
+// counters are updated before the listener closes.
+func drainSessions(notice time.Duration) {
+	sessions := localSessions.drain()
+	if len(sessions) == 0 {
+		return
+	}
+	log.Info("Draining sessions", "sessions", len(sessions), "notice", notice)
+	deadline := time.Now().Add(notice)
+	for _, s := range sessions {
+		s.program.Send(shutdownMsg(deadline))
+	}
+	// Wait out the countdown, or until every user has left on their own
+	for time.Now().Before(deadline) && localSessions.len() > 0 {
+		time.Sleep(250 * time.Millisecond)
+	}
+	for _, s := range sessions {
+		if err := s.user.Close(); err != nil {
+			log.Warn("Could not end session", "session", s.user.sessionID, "error", err)
+		}
+		s.program.Quit()
+	}
+}
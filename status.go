@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const matchesPrefix = "matches:" // Matches made per minute, keyed by unix minute
+
+// statusInterval is how often each instance recomputes the status it serves.
+const statusInterval = 2 * time.Second
+
+// latestStatus is the status last computed by runStatus, nil until the first
+// computation succeeds.
+var latestStatus atomic.Pointer[Status]
+
+// version is the version of this build, set with -ldflags "-X main.version=...".
+var version = "dev"
+
+// Status is the public summary of the service served at /status.
+type Status struct {
+	Online          int    `json:"online"`            // Sessions with a live presence
+	Queued          int    `json:"queued"`            // Live sessions waiting for a match
+	InChat          int    `json:"in_chat"`           // Live sessions matched with a stranger
+	MatchesLastHour int64  `json:"matches_last_hour"` // Pairs matched over the last hour
+	Version         string `json:"version"`           // Version of the server answering
+}
+
+// recordMatch counts a match in the bucket of the current minute.
+func recordMatch(b Broker) {
+	key := matchesPrefix + strconv.FormatInt(time.Now().Unix()/60, 10)
+	n, err := b.Incr(key)
+	if err == nil && n == 1 {
+		_ = b.Expire(key, time.Hour+time.Minute)
+	}
+}
+
+// matchesSince sums the per-minute match counts over the last d.
+func matchesSince(b Broker, d time.Duration) (int64, error) {
+	now := time.Now().Unix() / 60
+	var total int64
+	for minute := now - int64(d/time.Minute) + 1; minute <= now; minute++ {
+		v, err := b.Get(matchesPrefix + strconv.FormatInt(minute, 10))
+		if errors.Is(err, ErrNil) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		n, _ := strconv.ParseInt(v, 10, 64)
+		total += n
+	}
+	return total, nil
+}
+
+// currentStatus computes the status of the service. Sessions are only counted
+// while their presence key is alive, so sessions that died uncleanly drop out
+// as soon as their presence expires.
+func currentStatus(b Broker) (Status, error) {
+	status := Status{Version: version}
+	sessions, err := b.SMembers(sessionsKey)
+	if err != nil {
+		return status, err
+	}
+	queue, err := b.LRange(queueKey)
+	if err != nil {
+		return status, err
+	}
+	for _, id := range sessions {
+		if present, err := b.Exists(presencePrefix + id); err != nil || !present {
+			continue
+		}
+		status.Online++
+		if paired, err := b.Exists(pairPrefix + id); err == nil && paired {
+			status.InChat++
+		} else if slices.Contains(queue, id) {
+			status.Queued++
+		}
+	}
+	status.MatchesLastHour, err = matchesSince(b, time.Hour)
+	return status, err
+}
+
+// runStatus recomputes the status every statusInterval, so lobby viewers and
+// status requests share one scan of the sessions per instance.
+func runStatus(b Broker) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		status, err := currentStatus(b)
+		if err != nil {
+			log.Warn("Could not compute status", "error", err)
+		} else {
+			latestStatus.Store(&status)
+		}
+		<-ticker.C
+	}
+}
+
+// statusHandler serves the latest status as JSON.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := latestStatus.Load()
+	if status == nil {
+		http.Error(w, "status unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=5")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Limits applied to user supplied interest tags.
+const (
+	maxTags      = 5
+	maxTagLength = 20
+)
+
+// parseTags splits a comma separated list of interest tags, normalizing each tag
+// to lower case and dropping empty, overly long and duplicate entries.
+func parseTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || len(t) > maxTagLength || slices.Contains(tags, t) {
+			continue
+		}
+		tags = append(tags, t)
+		if len(tags) == maxTags {
+			break
+		}
+	}
+	return tags
+}
+
+// sharedTags returns the tags present in both a and b, in the order of a.
+func sharedTags(a, b []string) []string {
+	var shared []string
+	for _, t := range a {
+		if slices.Contains(b, t) {
+			shared = append(shared, t)
+		}
+	}
+	return shared
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{" , ,", nil},
+		{"go", []string{"go"}},
+		{"Go, MUSIC ,go", []string{"go", "music"}},
+		{"a,b,c,d,e,f", []string{"a", "b", "c", "d", "e"}},
+		{strings.Repeat("x", maxTagLength+1) + ",ok", []string{"ok"}},
+		{strings.Repeat("x", maxTagLength), []string{strings.Repeat("x", maxTagLength)}},
+	}
+	for _, tt := range tests {
+		if got := parseTags(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("parseTags(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSharedTags(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want []string
+	}{
+		{nil, []string{"go"}, nil},
+		{[]string{"go"}, []string{"music"}, nil},
+		{[]string{"music", "go", "art"}, []string{"go", "music"}, []string{"music", "go"}},
+	}
+	for _, tt := range tests {
+		if got := sharedTags(tt.a, tt.b); !slices.Equal(got, tt.want) {
+			t.Errorf("sharedTags(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme is a color scheme covering every style of the chat interface.
+type Theme struct {
+	Name     string                 // Name used by the theme command and preferences
+	Text     lipgloss.TerminalColor // Chat and input text
+	Sender   lipgloss.TerminalColor // User's own name
+	Receiver lipgloss.TerminalColor // Names of strangers
+	Accent   lipgloss.TerminalColor // Titles, prompts and focused rows
+	System   lipgloss.TerminalColor // Lines from GoMegle itself
+	Error    lipgloss.TerminalColor // Errors and warnings
+	Dim      lipgloss.TerminalColor // Timestamps, hints and placeholders
+	BannerFg lipgloss.TerminalColor // Text of announcement and shutdown banners
+	BannerBg lipgloss.TerminalColor // Background of announcement and shutdown banners
+	Bold     bool                   // Whether names, titles and errors are bold
+}
+
+// themes are the built-in themes, the first being the default.
+var themes = []Theme{
+	{
+		Name:     "default",
+		Text:     lipgloss.NoColor{},
+		Sender:   lipgloss.Color("5"),
+		Receiver: lipgloss.Color("3"),
+		Accent:   lipgloss.Color("3"),
+		System:   lipgloss.NoColor{},
+		Error:    lipgloss.Color("1"),
+		Dim:      lipgloss.Color("8"),
+		BannerFg: lipgloss.Color("0"),
+		BannerBg: lipgloss.Color("3"),
+	},
+	{
+		Name:     "ocean",
+		Text:     lipgloss.NoColor{},
+		Sender:   lipgloss.Color("6"),
+		Receiver: lipgloss.Color("4"),
+		Accent:   lipgloss.Color("6"),
+		System:   lipgloss.Color("12"),
+		Error:    lipgloss.Color("9"),
+		Dim:      lipgloss.Color("8"),
+		BannerFg: lipgloss.Color("15"),
+		BannerBg: lipgloss.Color("4"),
+	},
+	{
+		Name:     "forest",
+		Text:     lipgloss.NoColor{},
+		Sender:   lipgloss.Color("2"),
+		Receiver: lipgloss.Color("10"),
+		Accent:   lipgloss.Color("2"),
+		System:   lipgloss.Color("7"),
+		Error:    lipgloss.Color("1"),
+		Dim:      lipgloss.Color("8"),
+		BannerFg: lipgloss.Color("0"),
+		BannerBg: lipgloss.Color("2"),
+	},
+	{
+		Name:     "mono",
+		Text:     lipgloss.NoColor{},
+		Sender:   lipgloss.NoColor{},
+		Receiver: lipgloss.NoColor{},
+		Accent:   lipgloss.NoColor{},
+		System:   lipgloss.NoColor{},
+		Error:    lipgloss.NoColor{},
+		Dim:      lipgloss.NoColor{},
+		BannerFg: lipgloss.NoColor{},
+		BannerBg: lipgloss.NoColor{},
+		Bold:     true,
+	},
+	{
+		Name:     "high-contrast",
+		Text:     lipgloss.Color("15"),
+		Sender:   lipgloss.Color("11"),
+		Receiver: lipgloss.Color("14"),
+		Accent:   lipgloss.Color("15"),
+		System:   lipgloss.Color("15"),
+		Error:    lipgloss.Color("9"),
+		Dim:      lipgloss.Color("7"),
+		BannerFg: lipgloss.Color("0"),
+		BannerBg: lipgloss.Color("11"),
+		Bold:     true,
+	},
+}
+
+// themeByName returns the built-in theme with the given name.
+func themeByName(name string) (Theme, bool) {
+	i := slices.IndexFunc(themes, func(t Theme) bool { return t.Name == name })
+	if i < 0 {
+		return Theme{}, false
+	}
+	return themes[i], true
+}
+
+// themeNames returns the names of the built-in themes.
+func themeNames() []string {
+	names := make([]string, len(themes))
+	for i, t := range themes {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// themeStyles are the styles of a Theme, built for one session's renderer so
+// they match the client's color profile.
+type themeStyles struct {
+	text     lipgloss.Style // Chat text
+	sender   lipgloss.Style // User's own name
+	receiver lipgloss.Style // Names of strangers
+	title    lipgloss.Style // Titles and the splash spinner
+	focus    lipgloss.Style // Focused row of a form
+	system   lipgloss.Style // Lines from GoMegle itself
+	err      lipgloss.Style // Errors and warnings
+	dim      lipgloss.Style // Timestamps, hints and placeholders
+	banner   lipgloss.Style // Announcement and shutdown banners
+}
+
+// newThemeStyles builds the styles of t for the renderer r.
+func newThemeStyles(r *lipgloss.Renderer, t Theme) themeStyles {
+	return themeStyles{
+		text:     r.NewStyle().Foreground(t.Text),
+		sender:   r.NewStyle().Foreground(t.Sender).Bold(t.Bold),
+		receiver: r.NewStyle().Foreground(t.Receiver).Bold(t.Bold),
+		title:    r.NewStyle().Foreground(t.Accent).Bold(t.Bold),
+		focus:    r.NewStyle().Foreground(t.Accent).Bold(true),
+		system:   r.NewStyle().Foreground(t.System),
+		err:      r.NewStyle().Foreground(t.Error).Bold(t.Bold),
+		dim:      r.NewStyle().Foreground(t.Dim).Faint(t.Dim == lipgloss.NoColor{}),
+		banner:   r.NewStyle().Bold(true).Foreground(t.BannerFg).Background(t.BannerBg).Reverse(t.BannerBg == lipgloss.NoColor{}).Padding(0, 1),
+	}
+}
+
+// styleTextArea applies the theme styles, built for renderer r, to the message
+// input.
+func styleTextArea(ta *textarea.Model, r *lipgloss.Renderer, s themeStyles) {
+	for _, style := range []*textarea.Style{&ta.FocusedStyle, &ta.BlurredStyle} {
+		style.Base = r.NewStyle()
+		style.CursorLineNumber = s.dim
+		style.Text = s.text
+		style.Placeholder = s.dim
+		style.Prompt = s.title
+		style.EndOfBuffer = s.dim
+		style.CursorLine = s.text // No line highlighting
+	}
+	ta.Cursor.Style = s.title
+	ta.Cursor.TextStyle = s.text
+}
+
+// styleTextInput applies the theme styles to a lobby input.
+func styleTextInput(ti *textinput.Model, s themeStyles) {
+	ti.TextStyle = s.text
+	ti.PlaceholderStyle = s.dim
+	ti.Cursor.Style = s.title
+	ti.Cursor.TextStyle = s.text
+}
+
+// applyTheme switches the session to the named theme, falling back to the
+// default theme if there is no such theme.
+func (m model) applyTheme(name string) model {
+	t, ok := themeByName(name)
+	if !ok {
+		t = themes[0]
+	}
+	m.styles = newThemeStyles(m.renderer, t)
+	m.viewport.Style = m.renderer.NewStyle() // Messages carry their own styles
+	styleTextArea(&m.textarea, m.renderer, m.styles)
+	styleTextInput(&m.lobby.tags, m.styles)
+	styleTextInput(&m.lobby.room, m.styles)
+	styleTextInput(&m.lobby.nickname, m.styles)
+	return m
+}
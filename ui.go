@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -11,7 +13,9 @@ import (
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
 	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
 	"github.com/charmbracelet/wish/bubbletea"
 	gossh "golang.org/x/crypto/ssh"
 )
@@ -19,20 +23,9 @@ import (
 // Constants and styles used for splash screen rendering and styling.
 const (
 	splashMessage  = "Welcome to GoMegle"
-	welcomeMessage = "Welcome to GoMegle!\nSend '\\h' at any time to open help menu.\nLooking for someone to chat with..."
+	welcomeMessage = "Welcome to GoMegle!\nSend '\\h' at any time to open help menu."
 )
 
-const helpText = `
-\h     - Show this help menu
-\q     - Disconnect from current chat, or queue
-\r     - Requeue for a new chat
-\a 	   - Toggle auto-requeue
-\c     - Clear chat window
-
-q      - Exit this help menu
-ctrl+c - Exit the app at any time
-`
-
 var gap = "\n\n" // Space between components
 
 // errMsg is used to encapsulate error messages into Bubble Tea Msgs.
@@ -47,16 +40,54 @@ const (
 	StateUIMenu UIState = iota
 	StateUIChat
 	StateUIHelp
+	StateUISettings
+)
+
+// ackTimeoutMsg fires when a sent message has waited ackTimeout for its ACK.
+type ackTimeoutMsg string
+
+// Typing indicator timings. While typing, a TYPING message is sent at most once
+// per typingThrottle; the stranger's indicator is cleared typingTimeout after
+// the last one arrives.
+const (
+	typingThrottle = 2 * time.Second
+	typingTimeout  = 4 * time.Second
 )
 
+// floodQuitDelay is how long a user disconnected for flooding, or kicked by a
+// moderator, sees the notice before the session ends.
+const floodQuitDelay = 2 * time.Second
+
+// typingTimeoutMsg fires when a typing indicator may have expired. It carries
+// the typing sequence number it was scheduled for.
+type typingTimeoutMsg int
+
+// shutdownTickMsg refreshes the shutdown countdown once per second.
+type shutdownTickMsg struct{}
+
 type ChatState int
 
 const (
 	StateChatMatched ChatState = iota
 	StateChatQueued
 	StateChatDisconnected
+	StateChatRoom
 )
 
+func (s ChatState) String() string {
+	switch s {
+	case StateChatMatched:
+		return "in a chat"
+	case StateChatQueued:
+		return "queued"
+	case StateChatDisconnected:
+		return "disconnected"
+	case StateChatRoom:
+		return "in a room"
+	}
+	return ""
+}
+
 // model defines the state of the Bubble Tea TUI application.
 type model struct {
 	width           int                // Terminal width
@@ -66,37 +97,49 @@ type model struct {
 	splashText      string             // Currently displayed splash message
 	splashTextIndex int                // Index of next char to append to splashText
 	splashSpinner   spinner.Model      // Spinner animation during splash
-	splashStyle     lipgloss.Style     // Style for splash text
 	viewport        viewport.Model     // Scrollable text window for chat
-	messages        []string           // All messages displayed
+	messages        []chatEntry        // Chat log shown in the viewport
 	textarea        textarea.Model     // Input field for user to type messages
-	senderStyle     lipgloss.Style     // Style for user's message prefix
-	receiverStyle   lipgloss.Style     // Style for stranger's message prefix
+	styles          themeStyles        // Styles of the user's theme
+	lobby           lobby              // Lobby form shown before chatting
 	err             error              // Captured errors
 	user            *User              // User channels for sending/receiving
 	uiState         UIState            // Current state of the UI
 	chatState       ChatState          // Current state of the chat
-	autoRequeue     bool               // Whether to auto-requeue after disconnect
-	incrFailed      bool               // Whether incrementing active users failed
+	prefs           Preferences        // Settings of the user's key
+	settingsFocus   setting            // Focused row of the settings screen
+	settingsBack    UIState            // Screen to return to from the settings
+	out             io.Writer          // Session output, used to ring the bell
+	lastTypingSent  time.Time          // When a TYPING message was last sent
+	strangerTyping  bool               // Whether the stranger is typing
+	typingSeq       int                // Number of TYPING messages received
+	shutdownAt      time.Time          // When the server shuts down, zero while running
 }
 
 // teaHandler wires a Bubble Tea model to a new SSH session.
 // This returns the model and Bubble Tea options, such as using the alt screen.
 func teaHandler(s ssh.Session) (tea.Model, []tea.ProgramOption) {
-	return initialModel(s), []tea.ProgramOption{tea.WithAltScreen()}
+	if s.User() == adminUser {
+		return adminHandler(s)
+	}
+	m, err := initialModel(s)
+	if err != nil {
+		log.Error("Could not create session", "error", err)
+		wish.Fatalln(s, "Could not start a session. Try again later.")
+		return nil, nil
+	}
+	return m, []tea.ProgramOption{tea.WithAltScreen()}
 }
 
 // initialModel initializes the Bubble Tea model with session-specific settings.
-func initialModel(s ssh.Session) model {
+func initialModel(s ssh.Session) (model, error) {
 	// Setup input box
 	ta := textarea.New()
 	ta.Placeholder = "Send a message..."
-	ta.Focus()
 	ta.Prompt = "┃ "
-	ta.CharLimit = 280
+	ta.CharLimit = config.UI.CharLimit
 	ta.SetWidth(30)
 	ta.SetHeight(3)
-	ta.FocusedStyle.CursorLine = lipgloss.NewStyle() // No line highlighting
 	ta.ShowLineNumbers = false
 	ta.KeyMap.InsertNewline.SetEnabled(false) // Enter = submit
 
@@ -105,31 +148,37 @@ func initialModel(s ssh.Session) model {
 	// vp.SetContent("Welcome to GoMegle!\nLooking for someone to chat with...")
 
 	// Splash screen timer and spinner
-	timer := timer.NewWithInterval(2*time.Second, 30*time.Millisecond)
-	r := bubbletea.MakeRenderer(s)
-	switchTextAreaStyle(&ta, r)               // Apply renderer styles to textarea
-	vp.Style = r.NewStyle().Inherit(vp.Style) // Apply renderer styles to viewport
+	timer := timer.NewWithInterval(config.UI.SplashDuration, 30*time.Millisecond)
+	r := bubbletea.MakeRenderer(s) // Styles of the theme are built for this renderer
 
 	ss := spinner.New()
 	ss.Spinner = spinner.Dot
 
-	pk := string(gossh.MarshalAuthorizedKey(s.PublicKey()))
+	fp := gossh.FingerprintSHA256(s.PublicKey())
+	prefs, err := loadPreferences(globalBroker, fp)
+	if err != nil {
+		log.Warn("Could not load preferences", "fingerprint", fp, "error", err)
+	}
 
 	// Create user with channels and add to matchmaker
-	pubsub := rdb.Subscribe(ctx, "user:"+pk)
-	ch := pubsub.Channel()
-	user := &User{
-		pubKey:  pk,
-		pubsub:  pubsub,
-		receive: ch, // Buffered to prevent blocking
+	user, err := NewUser(globalBroker, fp, newRateLimiter(globalBroker, fp), messageFilters)
+	if err != nil {
+		return model{}, err
+	}
+	// Interest tags may be passed as the SSH user, e.g. ssh tags=go,music@host
+	var tags []string
+	if t, ok := strings.CutPrefix(s.User(), "tags="); ok {
+		tags = parseTags(t)
 	}
-	// Add user to matchmaker queue
-	// globalMatchmaker.Enqueue(user)
-	// Increment player count
-	_, err := rdb.Incr(ctx, "active").Result()
-	incrFailed := err != nil
+	// End the session if the connection drops without the user quitting
+	go func() {
+		<-s.Context().Done()
+		if err := user.Close(); err != nil {
+			log.Error("Could not end session", "session", user.sessionID, "error", err)
+		}
+	}()
 
-	return model{
+	m := model{
 		width:           30,
 		height:          10,
 		renderer:        r,
@@ -137,43 +186,18 @@ func initialModel(s ssh.Session) model {
 		splashText:      "",
 		splashTextIndex: 0,
 		splashSpinner:   ss,
-		splashStyle:     r.NewStyle().Foreground(lipgloss.Color("3")),
 		textarea:        ta,
-		messages:        []string{welcomeMessage},
 		viewport:        vp,
-		senderStyle:     r.NewStyle().Foreground(lipgloss.Color("5")),
-		receiverStyle:   r.NewStyle().Foreground(lipgloss.Color("3")),
+		lobby:           newLobby(tags),
 		user:            user,
 		uiState:         StateUIMenu,
 		chatState:       StateChatDisconnected,
-		autoRequeue:     false, // Auto-requeue disabled by default
-		incrFailed:      incrFailed,
+		prefs:           prefs,
+		out:             s,
 	}
-}
-
-// switchTextAreaStyle switches the textarea styles to use the renderer's styles.
-func switchTextAreaStyle(ta *textarea.Model, r *lipgloss.Renderer) {
-	// Apply renderer to cursor
-	ta.Cursor.Style = r.NewStyle().Inherit(ta.Cursor.Style)
-	ta.Cursor.TextStyle = r.NewStyle().Inherit(ta.Cursor.TextStyle)
-	// Apply renderer to focused styles
-	ta.FocusedStyle.Base = r.NewStyle().Inherit(ta.FocusedStyle.Base)
-	ta.FocusedStyle.CursorLine = r.NewStyle().Inherit(ta.FocusedStyle.CursorLine)
-	ta.FocusedStyle.Placeholder = r.NewStyle().Inherit(ta.FocusedStyle.Placeholder)
-	ta.FocusedStyle.CursorLineNumber = r.NewStyle().Inherit(ta.FocusedStyle.CursorLineNumber)
-	ta.FocusedStyle.EndOfBuffer = r.NewStyle().Inherit(ta.FocusedStyle.EndOfBuffer)
-	ta.FocusedStyle.Placeholder = r.NewStyle().Inherit(ta.FocusedStyle.Placeholder)
-	ta.FocusedStyle.Prompt = r.NewStyle().Inherit(ta.FocusedStyle.Prompt)
-	ta.FocusedStyle.Text = r.NewStyle().Inherit(ta.FocusedStyle.Text)
-	// Apply renderer to blurred styles
-	ta.BlurredStyle.Base = r.NewStyle().Inherit(ta.BlurredStyle.Base)
-	ta.BlurredStyle.CursorLine = r.NewStyle().Inherit(ta.BlurredStyle.CursorLine)
-	ta.BlurredStyle.Placeholder = r.NewStyle().Inherit(ta.BlurredStyle.Placeholder)
-	ta.BlurredStyle.CursorLineNumber = r.NewStyle().Inherit(ta.BlurredStyle.CursorLineNumber)
-	ta.BlurredStyle.EndOfBuffer = r.NewStyle().Inherit(ta.BlurredStyle.EndOfBuffer)
-	ta.BlurredStyle.Placeholder = r.NewStyle().Inherit(ta.BlurredStyle.Placeholder)
-	ta.BlurredStyle.Prompt = r.NewStyle().Inherit(ta.BlurredStyle.Prompt)
-	ta.BlurredStyle.Text = r.NewStyle().Inherit(ta.BlurredStyle.Text)
+	m = m.applyPreferences()
+	m.addSystem(welcomeMessage)
+	return m, nil
 }
 
 // Init initializes the Bubble Tea program with starting commands.
@@ -193,6 +217,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		tiCmd tea.Cmd
 		vpCmd tea.Cmd
 		ssCmd tea.Cmd
+		lbCmd tea.Cmd
 	)
 
 	// Always update textarea and viewport regardless of msg type
@@ -201,65 +226,130 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case timer.TimeoutMsg:
-		m.uiState = StateUIChat
-		// Enqueue the user after the splash screen times out
-		if m.chatState != StateChatMatched {
-			if err := globalMatchmaker.Enqueue(m.user); err == nil {
-				m.chatState = StateChatQueued
-			} else {
-				m.chatState = StateChatDisconnected
-				m.messages = append(m.messages, "Error: Could not enqueue. Try again later.")
-			}
+		// Show the lobby after the splash screen times out
+		m, lbCmd = m.openLobby()
+	case lobbyStatsMsg:
+		if msg.seq != m.lobby.seq {
+			return m, nil // The user left this lobby visit
+		}
+		m.lobby.online, m.lobby.queued = msg.online, msg.queued
+		return m, tea.Tick(lobbyRefreshInterval, func(time.Time) tea.Msg {
+			return lobbyRefreshMsg(msg.seq)
+		})
+	case lobbyRefreshMsg:
+		if int(msg) != m.lobby.seq {
+			return m, nil
 		}
+		return m, fetchLobbyStats(m.lobby.seq)
 	case tea.WindowSizeMsg:
 		// Adjust dimensions to fit the terminal
 		m.width = msg.Width
 		m.height = msg.Height
-		m.viewport.Width = msg.Width
-		m.textarea.SetWidth(msg.Width)
-		m.viewport.Height = msg.Height - m.textarea.Height() - lipgloss.Height(gap)
-
-		// Rewrap messages when resizing
-		if len(m.messages) > 0 {
-			m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.messages, "\n")))
-		}
-		m.viewport.GotoBottom()
+		m = m.resize()
 
 	case tea.KeyMsg:
 		return m.handleKeyMsg(msg)
 	case chatMsgReceived:
+		var cmd tea.Cmd // Bell rung by a new message
 		// Handle received messages from other users
 		switch msg.Type {
 		case ChatMsgTypeJoin:
+			if m.chatState == StateChatRoom {
+				m.addSystem("→ " + displayName(msg.Nickname) + " joined the room")
+				break
+			}
 			m.chatState = StateChatMatched
-			m.user.send = msg.Content // Set the other user's public key
-			m.messages = append(m.messages, "✅ You matched with a stranger, say hello!")
+			m.addSystem("✅ You matched with a stranger, say hello!")
+			if len(msg.Tags) > 0 {
+				m.addSystem("You both like: " + strings.Join(msg.Tags, ", "))
+			}
+		case ChatMsgTypeTyping:
+			m.strangerTyping = true
+			m.typingSeq++
+			seq := m.typingSeq
+			return m, tea.Batch(m.user.ListenForMessages(), tea.Tick(typingTimeout, func(time.Time) tea.Msg {
+				return typingTimeoutMsg(seq)
+			}))
 		case ChatMsgTypeMessage:
-			m.messages = append(m.messages, m.receiverStyle.Render("Stranger: ")+msg.Content)
+			m.strangerTyping = false
+			if m.chatState == StateChatRoom {
+				// Room messages are not acknowledged
+				m.add(chatEntry{kind: entryReceived, author: displayName(msg.Nickname), body: msg.Content})
+				cmd = m.ringBell()
+				break
+			}
+			// Acknowledge receipt, skipping retries of messages already shown
+			if isNew, _ := m.user.Acknowledge(msg); isNew {
+				m.add(chatEntry{kind: entryReceived, author: displayName(msg.Nickname), body: msg.Content})
+				cmd = m.ringBell()
+			}
+		case ChatMsgTypeAck:
+			if m.user.Acknowledged(msg.Id) {
+				m.setDelivery(msg.Id, deliveryDelivered)
+			}
 		case ChatMsgTypeLeave:
-			m.chatState = StateChatDisconnected
-			m.user.send = "" // Clear send channel
-			m.messages = append(m.messages, "❌ "+msg.Content)
-			if m.autoRequeue {
-				if err := globalMatchmaker.Enqueue(m.user); err == nil {
-					m.chatState = StateChatQueued
-					m.messages = append(m.messages, "Auto-requeue enabled! Waiting for a new match...")
-				} else {
-					m.messages = append(m.messages, "Error: Could not auto-requeue. Try again later.")
-				}
+			m.strangerTyping = false
+			if m.chatState == StateChatRoom {
+				m.addSystem("← " + displayName(msg.Nickname) + " left the room")
 			} else {
-				m.messages = append(m.messages, "Send '\\r' to requeue or press 'ctrl+c' to exit.")
+				m = m.partnerDisconnected(msg.Content)
 			}
 		case ChatMsgTypeError:
-			m.messages = append(m.messages, "🚨 "+msg.Content)
+			m.addError("🚨 " + msg.Content)
+		case ChatMsgTypeAnnouncement:
+			m.add(chatEntry{kind: entryAnnouncement, body: msg.Content})
+		case ChatMsgTypeKick:
+			// Removed by a moderator, show why before the session ends
+			_ = m.user.LeaveChat()
+			_ = m.user.LeaveRoom()
+			m.chatState = StateChatDisconnected
+			m.addError("🚨 " + msg.Content)
+			m.refreshViewport()
+			return m, tea.Tick(floodQuitDelay, func(time.Time) tea.Msg {
+				return tea.QuitMsg{}
+			})
 		}
 
 		// Update viewport and scroll to bottom
-		m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.messages, "\n")))
-		m.viewport.GotoBottom()
+		m.refreshViewport()
 
 		// Continue listening for more messages
-		return m, m.user.ListenForMessages()
+		return m, tea.Batch(m.user.ListenForMessages(), cmd)
+
+	case shutdownMsg:
+		// The server is draining, count down until it ends the session
+		m.shutdownAt = time.Time(msg)
+		return m, shutdownTick()
+
+	case shutdownTickMsg:
+		if time.Now().Before(m.shutdownAt) {
+			return m, shutdownTick()
+		}
+		return m, nil
+
+	case typingTimeoutMsg:
+		// Only clear the indicator if no newer TYPING arrived since
+		if int(msg) == m.typingSeq {
+			m.strangerTyping = false
+		}
+		return m, nil
+
+	case ackTimeoutMsg:
+		// Resend a message that was not acknowledged in time, or give up on it
+		id := string(msg)
+		retried, err := m.user.RetryPending(id)
+		if err == nil && retried {
+			return m, waitForAck(id)
+		}
+		if !m.user.IsPending(id) {
+			m.setDelivery(id, deliveryFailed)
+		}
+		if errors.Is(err, ErrPartnerGone) && m.chatState == StateChatMatched {
+			// The stranger vanished while the message was being resent
+			m = m.partnerDisconnected(lostConnectionMessage)
+		}
+		m.refreshViewport()
+		return m, nil
 
 	case timer.TickMsg:
 		// Splash text reveal logic
@@ -280,10 +370,67 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	// Combine all returned commands
-	return m, tea.Batch(taCmd, tiCmd, vpCmd, ssCmd)
+	return m, tea.Batch(taCmd, tiCmd, vpCmd, ssCmd, lbCmd)
+}
+
+// resize fits the viewport and textarea to the terminal, using a single line
+// input in compact mode, and rewraps the messages.
+func (m model) resize() model {
+	m.viewport.Width = m.width
+	m.textarea.SetWidth(m.width)
+	if m.prefs.Compact {
+		m.textarea.SetHeight(1)
+	} else {
+		m.textarea.SetHeight(3)
+	}
+	m.viewport.Height = max(0, m.height-m.textarea.Height()-lipgloss.Height(gap))
+	m.refreshViewport()
+	return m
+}
+
+// waitForAck schedules a check that the message with the given ID was acknowledged.
+func waitForAck(id string) tea.Cmd {
+	return tea.Tick(ackTimeout, func(time.Time) tea.Msg {
+		return ackTimeoutMsg(id)
+	})
+}
+
+// shutdownTick schedules the next refresh of the shutdown countdown.
+func shutdownTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return shutdownTickMsg{}
+	})
+}
+
+// displayName returns the name to show for a sender with the given nickname.
+func displayName(nickname string) string {
+	if nickname == "" {
+		return "Stranger"
+	}
+	return nickname
+}
+
+// partnerDisconnected moves the chat to the disconnected state after the
+// stranger left or was lost, auto-requeuing the user if enabled.
+func (m model) partnerDisconnected(reason string) model {
+	m.chatState = StateChatDisconnected
+	_ = m.user.PartnerLeft() // Clear send channel
+	m.addSystem("❌ " + reason)
+	if m.prefs.AutoRequeue {
+		if err := globalMatchmaker.Enqueue(m.user); err == nil {
+			m.chatState = StateChatQueued
+			m.addSystem("Auto-requeue enabled! Waiting for a new match...")
+		} else {
+			m.addError("Error: Could not auto-requeue. Try again later.")
+		}
+	} else {
+		m.addSystem("Send '\\r' to requeue or press 'ctrl+c' to exit.")
+	}
+	return m
 }
 
 func (m model) handleKeyMsg(msg tea.KeyMsg) (model, tea.Cmd) {
+	var cmd tea.Cmd
 	key := msg.String()
 	// global keybind ctrl+c to exit
 	if key == "ctrl+c" {
@@ -298,11 +445,8 @@ func (m model) handleKeyMsg(msg tea.KeyMsg) (model, tea.Cmd) {
 				fmt.Printf("Error leaving chat: %v\n", err)
 			}
 		}
-		if err := m.user.pubsub.Close(); err != nil { // Close the user's pubsub channel
-			fmt.Printf("Error closing pubsub: %v\n", err)
-		}
-		if !m.incrFailed {
-			rdb.Decr(ctx, "active") // Decrement active users
+		if err := m.user.Close(); err != nil { // End the user's session
+			fmt.Printf("Error closing session: %v\n", err)
 		}
 		// Exit the program
 		return m, tea.Quit
@@ -314,76 +458,58 @@ func (m model) handleKeyMsg(msg tea.KeyMsg) (model, tea.Cmd) {
 		// handle global keybinds when in chat UI, regardless of chat state
 		switch key {
 		case "enter":
-			switch strings.TrimSpace(m.textarea.Value()) {
-			case "":
-			case "\\h":
-				m.uiState = StateUIHelp
-			case "\\c":
-				var status string
-				switch m.chatState {
-				case StateChatMatched:
-					status = "in a chat!"
-				case StateChatQueued:
-					status = "queued!"
-				case StateChatDisconnected:
-					status = "disconnected!"
+			value := strings.TrimSpace(m.textarea.Value())
+			if _, _, ok := parseCommand(value); ok {
+				m, cmd = m.runCommand(value)
+			} else if value != "" && (m.chatState == StateChatMatched || m.chatState == StateChatRoom) {
+				chatMsg := &ChatMsg{
+					Type:    ChatMsgTypeMessage,
+					Content: value,
 				}
-				m.messages = []string{"Chat Cleared. Currently " + status}
-			case "\\r":
-				switch m.chatState {
-				case StateChatDisconnected:
-					if err := globalMatchmaker.Enqueue(m.user); err == nil {
-						m.chatState = StateChatQueued
-						m.messages = append(m.messages, "Re-queued! send '\\q' to exit queue or 'ctrl+c' to quit.")
-					} else {
-						m.messages = append(m.messages, "Error: Could not re-queue. Try again later.")
+				allowed, flooding := m.user.AllowSend()
+				if flooding {
+					// Repeat offender, leave the chat and disconnect shortly
+					_ = m.user.LeaveChat()
+					_ = m.user.LeaveRoom()
+					m.chatState = StateChatDisconnected
+					m.addError("🚨 You have been disconnected for flooding.")
+					cmd = tea.Tick(floodQuitDelay, func(time.Time) tea.Msg {
+						return tea.QuitMsg{}
+					})
+				} else if !allowed {
+					m.addSystem("🐢 Slow down! You are sending messages too fast.")
+				} else if m.chatState == StateChatRoom {
+					if content, err := m.user.SendRoomMessage(chatMsg.Content); err == nil {
+						m.add(chatEntry{kind: entrySent, body: content})
+					} else if rejected := (*RejectedError)(nil); !errors.As(err, &rejected) {
+						m.addError("Error: Could not send message")
 					}
-				}
-			case "\\a":
-				m.autoRequeue = !m.autoRequeue
-				var status string
-				if m.autoRequeue {
-					status = "enabled"
+				} else if err := m.user.SendMessage(chatMsg); err == nil {
+					// Message sent, add to our view until it is acknowledged
+					m.add(chatEntry{kind: entrySent, id: chatMsg.Id, body: chatMsg.Content, delivery: deliveryPending})
+					cmd = waitForAck(chatMsg.Id)
+				} else if errors.Is(err, ErrPartnerGone) {
+					// Nobody is listening, the stranger vanished without leaving
+					m = m.partnerDisconnected(lostConnectionMessage)
+				} else if rejected := (*RejectedError)(nil); errors.As(err, &rejected) {
+					// Filtered out, the reason arrives as an ERROR message
 				} else {
-					status = "disabled"
-				}
-				m.messages = append(m.messages, fmt.Sprintf("Auto-requeue %s. Send '\\h' for help.", status))
-			case "\\q":
-				switch m.chatState {
-				case StateChatMatched:
-					if err := m.user.LeaveChat(); err == nil {
-						m.chatState = StateChatDisconnected
-						m.messages = append(m.messages, "You have left the chat. Send '\\r' to requeue or press 'ctrl+c' to exit.")
-					} else {
-						m.messages = append(m.messages, "Error: Could not leave chat. Try again later.")
-					}
-				case StateChatQueued:
-					if err := globalMatchmaker.Dequeue(m.user); err == nil {
-						m.chatState = StateChatDisconnected
-						m.messages = append(m.messages, "You have left the queue. Send '\\r' to requeue or press 'ctrl+c' to exit.")
-					} else {
-						m.messages = append(m.messages, "Error: Could not leave queue. Try again later.")
-					}
-				}
-			default:
-				if m.chatState == StateChatMatched {
-					chatMsg := &ChatMsg{
-						Type:    ChatMsgTypeMessage,
-						Content: strings.TrimSpace(m.textarea.Value()),
-					}
-					// Send message to other user (non-blocking)
-					if err := m.user.SendMessage(chatMsg); err == nil {
-						// Message sent successfully, add to our view
-						m.messages = append(m.messages, m.senderStyle.Render("You: ")+m.textarea.Value())
-					} else {
-						// Channel is full or closed, show error
-						m.messages = append(m.messages, "Error: Could not send message")
-					}
+					// Channel is full or closed, show error
+					m.addError("Error: Could not send message")
 				}
 			}
+		case "tab":
+			m = m.completeCommand()
 		}
-	// In the menu UI
+	// In the lobby
 	case StateUIMenu:
+		if !m.splashTimer.Timedout() {
+			return m, nil // The lobby opens after the splash screen
+		}
+		return m.handleLobbyKey(msg)
+	// In the settings
+	case StateUISettings:
+		return m.handleSettingsKey(msg)
 	// In the help UI
 	case StateUIHelp:
 		if key == "q" {
@@ -393,15 +519,43 @@ func (m model) handleKeyMsg(msg tea.KeyMsg) (model, tea.Cmd) {
 	}
 	// global keybinds below, after handling state-specific keybinds
 	if key == "enter" {
-		m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.messages, "\n")))
-		m.viewport.GotoBottom()
+		m.refreshViewport()
 		m.textarea.Reset()
+	} else {
+		m = m.sendTyping()
 	}
-	return m, nil
+	return m, cmd
+}
+
+// sendTyping lets the stranger know the user is typing, at most once per
+// typingThrottle. Commands are not announced.
+func (m model) sendTyping() model {
+	value := strings.TrimSpace(m.textarea.Value())
+	if m.uiState != StateUIChat || m.chatState != StateChatMatched || value == "" || strings.HasPrefix(value, "\\") {
+		return m
+	}
+	if time.Since(m.lastTypingSent) < typingThrottle {
+		return m
+	}
+	m.lastTypingSent = time.Now()
+	_ = m.user.SendMessage(&ChatMsg{Type: ChatMsgTypeTyping})
+	return m
 }
 
 // View renders the entire UI depending on model state.
 func (m model) View() string {
+	// While draining, the chat shows the countdown above the input and full
+	// screen views give up their first line to it
+	banner := m.shutdownBanner()
+	if banner == "" || (m.splashTimer.Timedout() && m.uiState == StateUIChat) {
+		return m.screenView()
+	}
+	m.height--
+	return banner + "\n" + m.screenView()
+}
+
+// screenView renders the screen of the current UI state.
+func (m model) screenView() string {
 	if !m.splashTimer.Timedout() {
 		return splashView(m)
 	}
@@ -409,20 +563,41 @@ func (m model) View() string {
 	var view string
 
 	switch m.uiState {
+	case StateUIMenu:
+		view = lobbyView(m)
+	case StateUISettings:
+		view = settingsView(m)
 	case StateUIHelp:
 		view = helpView(m)
 	case StateUIChat:
 		if m.chatState == StateChatMatched {
 			m.textarea.Placeholder = "Type your message..."
+		} else if m.chatState == StateChatRoom {
+			m.textarea.Placeholder = "Message #" + m.user.Room() + "..."
 		} else {
 			m.textarea.Placeholder = "Waiting for match..." + m.splashSpinner.View()
 		}
-		view = fmt.Sprintf("%s%s%s", m.viewport.View(), gap, m.textarea.View())
+		// The shutdown countdown or typing indicator takes the first line of the gap
+		typing := m.shutdownBanner()
+		if typing == "" && m.strangerTyping && m.chatState == StateChatMatched {
+			typing = m.styles.receiver.Render("Stranger is typing…")
+		}
+		view = fmt.Sprintf("%s\n%s\n%s", m.viewport.View(), typing, m.textarea.View())
 	}
 
 	return view
 }
 
+// shutdownBanner renders the countdown to the server shutting down, empty while
+// the server is running.
+func (m model) shutdownBanner() string {
+	if m.shutdownAt.IsZero() {
+		return ""
+	}
+	left := max(0, time.Until(m.shutdownAt).Round(time.Second))
+	return m.styles.banner.Render(fmt.Sprintf("⚠ Server restarting in %s, reconnect to keep chatting", left))
+}
+
 // splashView renders the splash screen animation.
 func splashView(m model) string {
 	var spinnerText string
@@ -432,7 +607,7 @@ func splashView(m model) string {
 	return m.renderer.Place(
 		m.width, m.height,
 		lipgloss.Center, lipgloss.Center,
-		fmt.Sprintf("%s %s", m.splashText, m.splashStyle.Render(spinnerText)),
+		fmt.Sprintf("%s %s", m.splashText, m.styles.title.Render(spinnerText)),
 	)
 }
 
@@ -442,5 +617,5 @@ func helpView(m model) string {
 		Width(m.width).
 		Height(m.height).
 		Align(lipgloss.Left, lipgloss.Center).
-		Render(helpText)
+		Render(helpText())
 }
@@ -1,56 +1,400 @@
 package main
 
 import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/redis/go-redis/v9"
 	"google.golang.org/protobuf/proto"
 )
 
-// User represents a user in the matchmaker system
+// leftChatMessage is sent to the partner of a user that leaves the chat.
+const leftChatMessage = "Stranger has left the chat"
+
+// Delivery settings for chat messages. A message that has not been
+// acknowledged within ackTimeout is resent, up to maxSendAttempts times in total.
+const (
+	ackTimeout      = 2 * time.Second
+	maxSendAttempts = 3
+)
+
+// ErrPartnerGone is returned by SendMessage when nobody is listening on the
+// matched user's channel, meaning their session vanished without a LEAVE.
+var ErrPartnerGone = errors.New("partner is no longer connected")
+
+// User represents a user in the matchmaker system. Each SSH session is a separate
+// user with its own session ID, even when several sessions share one key.
 type User struct {
-	pubKey  string                // Public key of the user
-	pubsub  *redis.PubSub         // Redis PubSub instance for the user
-	receive <-chan *redis.Message // Channel to receive messages
-	send    string                // Channel to send messages
+	broker      Broker            // Backend used to send and receive messages
+	sessionID   string            // Unique ID of this session, used for routing
+	fingerprint string            // Fingerprint of the user's public key
+	sub         Subscription      // Subscription to the user's channel
+	receive     <-chan *PubSubMsg // Channel to receive messages
+	tags        []string          // Interest tags used for matchmaking
+	limiter     RateLimiter       // Limits how fast the user may send messages
+	filters     FilterChain       // Filters applied to outgoing messages
+	done        chan struct{}     // Closed when the user's session ends
+	closeOnce   sync.Once         // Guards closing the session
+
+	mu           sync.Mutex             // Guards the pairing and delivery state below
+	send         string                 // Session ID of the matched user
+	nickname     string                 // Name shown to others, empty for a stranger
+	room         string                 // Group room the user is in, empty if none
+	conversation string                 // ID of the current conversation
+	pending      map[string]*pendingMsg // Sent messages awaiting an ACK, by ID
+	seen         map[string]struct{}    // IDs of messages received from the current partner
+	transcript   []string               // Recent lines of the current chat, kept for reports
+	strikes      int                    // Number of recent rate limit violations
+	lastStrike   time.Time              // When the rate limit was last exceeded
+}
+
+// pendingMsg is a sent message that has not been acknowledged yet.
+type pendingMsg struct {
+	msg      *ChatMsg // The message as sent
+	attempts int      // Number of times the message has been sent
+}
+
+// NewUser creates a new session for the key with the given fingerprint,
+// registers it with the broker, subscribes it to its message channel and to
+// announcements, and starts its presence heartbeat. Messages sent by the user
+// are limited by limiter and passed through filters before being published.
+func NewUser(b Broker, fingerprint string, limiter RateLimiter, filters FilterChain) (*User, error) {
+	sessionID := newID(12)
+	if err := registerSession(b, sessionID, fingerprint); err != nil {
+		return nil, err
+	}
+	sub := b.Subscribe(userChannelPrefix+sessionID, announceChannel)
+	u := &User{
+		broker:      b,
+		sessionID:   sessionID,
+		fingerprint: fingerprint,
+		limiter:     limiter,
+		filters:     filters,
+		sub:         sub,
+		receive:     sub.Channel(),
+		done:        make(chan struct{}),
+		pending:     make(map[string]*pendingMsg),
+		seen:        make(map[string]struct{}),
+	}
+	go heartbeat(b, sessionID, u.done)
+	return u, nil
 }
 
-// ListenForMessages starts listening for messages on the user's receive channel
+// ListenForMessages starts listening for messages on the user's receive channel.
+// Frames that do not belong to the current pairing are dropped.
 func (u *User) ListenForMessages() tea.Cmd {
 	return func() tea.Msg {
-		content, ok := <-u.receive // Blocking call to wait for a message
-		if !ok {
-			return tea.Quit // If the channel is closed, quit the program
+		for {
+			content, ok := <-u.receive // Blocking call to wait for a message
+			if !ok {
+				return tea.Quit // If the channel is closed, quit the program
+			}
+			env := &Envelope{}
+			if err := proto.Unmarshal(content.Payload, env); err != nil {
+				return tea.Quit // If there's an error, quit the program
+			}
+			if !u.accept(env) {
+				continue
+			}
+			return chatMsgReceived(env.Msg)
 		}
-		msg := &ChatMsg{}
-		if err := proto.Unmarshal([]byte(content.Payload), msg); err != nil {
-			return tea.Quit // If there's an error, quit the program
+	}
+}
+
+// accept reports whether a received frame should be handed to the UI. Server
+// frames may only match an unmatched user, report errors, end the session or
+// carry announcements. Frames of the user's group room may come from anyone in
+// it; every other frame must come from the current partner in the current
+// conversation. An accepted JOIN starts the new pairing.
+func (u *User) accept(env *Envelope) bool {
+	if env.Version != protocolVersion || env.Msg == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if env.Sender == "" {
+		switch env.Msg.Type {
+		case ChatMsgTypeJoin:
+			if u.send != "" || env.Msg.Content == "" {
+				return false
+			}
+			u.send = env.Msg.Content
+			u.conversation = env.Conversation
+			return true
+		case ChatMsgTypeError, ChatMsgTypeKick, ChatMsgTypeAnnouncement:
+			return true
 		}
-		return chatMsgReceived(msg)
+		return false
 	}
+	if u.room != "" && env.Conversation == roomPrefix+u.room {
+		switch env.Msg.Type {
+		case ChatMsgTypeMessage:
+			messagesReceived.Inc()
+			return true
+		case ChatMsgTypeJoin, ChatMsgTypeLeave:
+			return true
+		}
+		return false
+	}
+	return env.Msg.Type != ChatMsgTypeJoin && env.Sender == u.send && env.Conversation == u.conversation
+}
+
+// partner returns the session ID of the matched user and the conversation ID,
+// both empty when unmatched.
+func (u *User) partner() (send, conversation string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.send, u.conversation
 }
 
 // SendMessage sends a message to the user's match channel. If the
 // send channel (string identifier) is empty, this function does nothing.
+// If no subscriber received the message, ErrPartnerGone is returned.
+// Regular messages are filtered, given an ID and tracked until acknowledged.
 func (u *User) SendMessage(msg *ChatMsg) error {
-	if u.send == "" {
+	if send, _ := u.partner(); send == "" {
 		return nil // If the send channel is not set, do nothing
 	}
-	data, err := proto.Marshal(msg)
+	if msg.Type == ChatMsgTypeMessage {
+		if msg.Id == "" {
+			// New message, run it through the outbound filters
+			content, err := u.filters.Apply(msg.Content)
+			if rejected := (*RejectedError)(nil); errors.As(err, &rejected) {
+				u.notify(rejected.Reason)
+			}
+			if err != nil {
+				return err
+			}
+			msg.Content = content
+			msg.Id = newID(8)
+			messagesSent.Inc()
+		}
+		u.mu.Lock()
+		if p, ok := u.pending[msg.Id]; ok {
+			p.attempts++
+		} else {
+			msg.Nickname = u.nickname
+			u.pending[msg.Id] = &pendingMsg{msg: msg, attempts: 1}
+			u.record("You: " + msg.Content)
+		}
+		u.mu.Unlock()
+	}
+	return u.publish(msg)
+}
+
+// notify sends an ERROR message to this user's own channel, reporting a problem
+// through the same path as every other server message.
+func (u *User) notify(content string) {
+	data, err := marshalFrame("", "", &ChatMsg{Type: ChatMsgTypeError, Content: content})
+	if err != nil {
+		return
+	}
+	_, _ = u.broker.Publish(userChannelPrefix+u.sessionID, data)
+}
+
+// AllowSend reports whether the user may send another message under its rate
+// limit. Each refusal counts as a strike; flooding is reported once the user has
+// collected maxStrikes strikes in quick succession and should be disconnected.
+func (u *User) AllowSend() (allowed, flooding bool) {
+	if u.limiter.Allow() {
+		return true, false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if time.Since(u.lastStrike) > strikeReset {
+		u.strikes = 0
+	}
+	u.strikes++
+	u.lastStrike = time.Now()
+	return false, u.strikes >= maxStrikes
+}
+
+// publish sends msg to the matched user without any delivery tracking.
+func (u *User) publish(msg *ChatMsg) error {
+	send, conversation := u.partner()
+	if send == "" {
+		return nil
+	}
+	data, err := marshalFrame(u.sessionID, conversation, msg)
 	if err != nil {
 		return err
 	}
-	return rdb.Publish(ctx, "user:"+u.send, data).Err()
+	n, err := u.broker.Publish(userChannelPrefix+send, data)
+	if err != nil {
+		publishErrors.WithLabelValues("broker").Inc()
+		return err
+	}
+	if n == 0 {
+		publishErrors.WithLabelValues("partner_gone").Inc()
+		return ErrPartnerGone
+	}
+	return nil
+}
+
+// Acknowledge sends an ACK for a received message and reports whether the
+// message is new, as opposed to a retry of one already received.
+func (u *User) Acknowledge(msg *ChatMsg) (bool, error) {
+	if msg.Id == "" {
+		return true, nil // Sender does not track delivery
+	}
+	u.mu.Lock()
+	_, dup := u.seen[msg.Id]
+	u.seen[msg.Id] = struct{}{}
+	if !dup {
+		u.record("Stranger: " + msg.Content)
+		messagesReceived.Inc()
+	}
+	u.mu.Unlock()
+	return !dup, u.publish(&ChatMsg{Type: ChatMsgTypeAck, Id: msg.Id})
 }
 
+// Acknowledged stops tracking the message with the given ID after its ACK was
+// received. It reports whether the message was still pending.
+func (u *User) Acknowledged(id string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, ok := u.pending[id]
+	delete(u.pending, id)
+	return ok
+}
+
+// RetryPending resends the message with the given ID if it is still awaiting an
+// ACK. It reports whether the message was resent; once all attempts are used,
+// the chat has ended or the resend fails, the message is dropped and false is
+// returned.
+func (u *User) RetryPending(id string) (bool, error) {
+	u.mu.Lock()
+	p, ok := u.pending[id]
+	if ok && (p.attempts >= maxSendAttempts || u.send == "") {
+		delete(u.pending, id)
+		ok = false
+	}
+	u.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if err := u.SendMessage(p.msg); err != nil {
+		u.mu.Lock()
+		delete(u.pending, id)
+		u.mu.Unlock()
+		return false, err
+	}
+	return true, nil
+}
+
+// IsPending reports whether the message with the given ID awaits an ACK.
+func (u *User) IsPending(id string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, ok := u.pending[id]
+	return ok
+}
+
+// unpair forgets the current pairing and all delivery state of the chat.
+func (u *User) unpair() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.send = ""
+	u.conversation = ""
+	clear(u.pending)
+	clear(u.seen)
+	u.transcript = nil
+}
+
+// record appends a line to the transcript of the current chat, keeping only the
+// most recent transcriptSize lines. The caller must hold u.mu.
+func (u *User) record(line string) {
+	u.transcript = append(u.transcript, line)
+	if len(u.transcript) > transcriptSize {
+		u.transcript = u.transcript[len(u.transcript)-transcriptSize:]
+	}
+}
+
+// ReportPartner files a report against the matched user with the given reason
+// and the recent transcript, then leaves the chat.
+func (u *User) ReportPartner(reason string) error {
+	send, _ := u.partner()
+	if send == "" {
+		return nil
+	}
+	reported, err := sessionFingerprint(u.broker, send)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	transcript := slices.Clone(u.transcript)
+	u.mu.Unlock()
+	report := Report{
+		ID:         newID(8),
+		Time:       time.Now(),
+		Reporter:   u.fingerprint,
+		Reported:   reported,
+		Reason:     reason,
+		Transcript: transcript,
+	}
+	if err := fileReport(u.broker, report); err != nil {
+		return err
+	}
+	return u.LeaveChat()
+}
+
+// BlockPartner adds the matched user's key to this user's blocklist so they are
+// never matched again, then leaves the chat.
+func (u *User) BlockPartner() error {
+	send, _ := u.partner()
+	if send == "" {
+		return nil
+	}
+	blocked, err := sessionFingerprint(u.broker, send)
+	if err != nil {
+		return err
+	}
+	if err := blockKey(u.broker, u.fingerprint, blocked); err != nil {
+		return err
+	}
+	return u.LeaveChat()
+}
+
+// LeaveChat tells the matched user that this user has left and forgets the pairing.
 func (u *User) LeaveChat() error {
+	send, _ := u.partner()
+	if send == "" {
+		return nil
+	}
+	if err := clearPair(u.broker, u.sessionID, send); err != nil {
+		return err
+	}
 	leaveMsg := &ChatMsg{
 		Type:    ChatMsgTypeLeave,
-		Content: "Stranger has left the chat",
+		Content: leftChatMessage,
 	}
 	err := u.SendMessage(leaveMsg)
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrPartnerGone) {
 		return err
 	}
-	u.send = ""
+	u.unpair()
 	return nil
 }
+
+// PartnerLeft forgets the pairing after the matched user has left the chat.
+func (u *User) PartnerLeft() error {
+	u.unpair()
+	return clearPair(u.broker, u.sessionID)
+}
+
+// Close unsubscribes the user from its message channel and ends its session,
+// telling a matched partner that this user left. It is safe to call more than once.
+func (u *User) Close() error {
+	var err error
+	u.closeOnce.Do(func() {
+		close(u.done)
+		_ = u.LeaveRoom() // endSession removes the membership if this fails
+		if err = u.sub.Close(); err != nil {
+			return
+		}
+		err = endSession(u.broker, u.sessionID, leftChatMessage)
+	})
+	return err
+}